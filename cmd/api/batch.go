@@ -0,0 +1,279 @@
+// Filename: cmd/api/batch.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The most operations a single batch may contain
+const maxBatchOperations = 100
+
+// errBatchOperationFailed stops a batch transaction when an operation fails
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// A batchOperation creates, updates or deletes one todo. Version is optional
+// and works like If-Match for updates and deletes
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int64  `json:"id"`
+	Version *int32 `json:"version"`
+	Todo    struct {
+		ListID   *int64     `json:"list_id"`
+		ParentID *int64     `json:"parent_id"`
+		Name     *string    `json:"name"`
+		Task     *string    `json:"task"`
+		Due      *time.Time `json:"due"`
+	} `json:"todo"`
+}
+
+// A batchResult reports the outcome of one operation using the status code
+// the matching single request would have returned
+type batchResult struct {
+	Index  int       `json:"index"`
+	Op     string    `json:"op"`
+	Status int       `json:"status"`
+	Todo   *todoItem `json:"todo,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	// Validation errors are also collected by index for the whole batch
+	validationErrors map[string]string
+}
+
+// batchTodoInfoHandler for the "POST /v1/todoInfo/batch" endpoint. In
+// "atomic" mode (the default) every operation succeeds or none is applied.
+// In "best_effort" mode each operation stands on its own
+func (app *application) batchTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Mode == "" {
+		input.Mode = "atomic"
+	}
+	v := validator.New()
+	v.Check(validator.In(input.Mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort")
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", "must not contain more than 100 operations")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	results := make([]batchResult, 0, len(input.Operations))
+	failed := false
+
+	// All the operations share one transaction
+	err = todos.InTx(func(tx data.TodoModel) error {
+		for i, op := range input.Operations {
+			var result batchResult
+			// In best effort mode a savepoint undoes just the failed operation
+			err := tx.Savepoint(func() error {
+				var err error
+				result, err = app.runBatchOperation(tx, i, op, userID)
+				if err != nil {
+					return err
+				}
+				if result.Status >= 400 {
+					return errBatchOperationFailed
+				}
+				return nil
+			})
+			results = append(results, result)
+			switch {
+			case errors.Is(err, errBatchOperationFailed):
+				failed = true
+				if input.Mode == "atomic" {
+					// The operations after the failed one are not run, but
+					// still get a result so clients can tell them apart
+					for j := i + 1; j < len(input.Operations); j++ {
+						results = append(results, batchResult{
+							Index:  j,
+							Op:     input.Operations[j].Op,
+							Status: http.StatusFailedDependency,
+							Error:  "not applied because an earlier operation failed",
+						})
+					}
+					return err
+				}
+			case err != nil:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchOperationFailed) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Collect the validation errors by operation index
+	validationErrors := make(map[string]map[string]string)
+	for i := range results {
+		if results[i].validationErrors != nil {
+			validationErrors[strconv.Itoa(results[i].Index)] = results[i].validationErrors
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case failed && input.Mode == "atomic":
+		// Nothing was applied, so the operations before the failed one that
+		// did succeed failed because of it
+		for i := range results {
+			if results[i].Status < 400 {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Todo = nil
+				results[i].Error = "rolled back because another operation failed"
+			}
+		}
+		status = http.StatusUnprocessableEntity
+	case failed:
+		status = http.StatusMultiStatus
+	}
+	env := envelope{"mode": input.Mode, "results": results}
+	if len(validationErrors) > 0 {
+		env["errors"] = validationErrors
+	}
+	err = app.writeJSON(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The runBatchOperation() method applies one operation of a batch. Failures
+// caused by the operation are reported in the result, only unexpected errors
+// are returned
+func (app *application) runBatchOperation(todos data.TodoModel, index int, op batchOperation, userID int64) (batchResult, error) {
+	result := batchResult{Index: index, Op: op.Op}
+	fail := func(status int, message string) (batchResult, error) {
+		result.Status = status
+		result.Error = message
+		return result, nil
+	}
+	invalid := func(v *validator.Validator) (batchResult, error) {
+		result.validationErrors = v.Errors
+		return fail(http.StatusUnprocessableEntity, "failed validation")
+	}
+
+	v := validator.New()
+	switch op.Op {
+	case "create":
+		todo := &data.Todo{CreatedBy: &userID, ListID: op.Todo.ListID, ParentID: op.Todo.ParentID, Due: op.Todo.Due}
+		if op.Todo.Name != nil {
+			todo.Name = *op.Todo.Name
+		}
+		if op.Todo.Task != nil {
+			todo.Task = *op.Todo.Task
+		}
+		if data.ValidateTodo(v, todo); !v.Valid() {
+			return invalid(v)
+		}
+		err := todos.Insert(todo)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrInvalidList):
+				v.AddError("list_id", "must be a list of this organization")
+				return invalid(v)
+			case errors.Is(err, data.ErrInvalidParent):
+				v.AddError("parent_id", parentIDError)
+				return invalid(v)
+			default:
+				return result, err
+			}
+		}
+		result.Status = http.StatusCreated
+		result.Todo = &todoItem{Todo: todo, ETag: todo.ETag()}
+		return result, nil
+
+	case "update":
+		todo, err := todos.Get(op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			default:
+				return result, err
+			}
+		}
+		if op.Version != nil && *op.Version != todo.Version {
+			return fail(http.StatusPreconditionFailed, "the resource has been modified since you last fetched it")
+		}
+		if op.Todo.ListID != nil {
+			todo.ListID = op.Todo.ListID
+		}
+		if op.Todo.ParentID != nil {
+			todo.ParentID = op.Todo.ParentID
+		}
+		if op.Todo.Name != nil {
+			todo.Name = *op.Todo.Name
+		}
+		if op.Todo.Task != nil {
+			todo.Task = *op.Todo.Task
+		}
+		if op.Todo.Due != nil {
+			todo.Due = op.Todo.Due
+		}
+		if data.ValidateTodo(v, todo); !v.Valid() {
+			return invalid(v)
+		}
+		err = todos.Update(todo)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return fail(http.StatusConflict, "unable to update the record due to an edit conflict")
+			case errors.Is(err, data.ErrInvalidList):
+				v.AddError("list_id", "must be a list of this organization")
+				return invalid(v)
+			case errors.Is(err, data.ErrInvalidParent):
+				v.AddError("parent_id", parentIDError)
+				return invalid(v)
+			default:
+				return result, err
+			}
+		}
+		result.Status = http.StatusOK
+		result.Todo = &todoItem{Todo: todo, ETag: todo.ETag()}
+		return result, nil
+
+	case "delete":
+		var err error
+		if op.Version != nil {
+			// A todo that does not exist is a 404, not a version mismatch
+			_, err = todos.Get(op.ID)
+			if err == nil {
+				err = todos.DeleteVersion(op.ID, *op.Version)
+			}
+		} else {
+			err = todos.Delete(op.ID)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			case errors.Is(err, data.ErrEditConflict):
+				return fail(http.StatusPreconditionFailed, "the resource has been modified since you last fetched it")
+			default:
+				return result, err
+			}
+		}
+		result.Status = http.StatusOK
+		return result, nil
+
+	default:
+		v.AddError("op", "must be create, update or delete")
+		return invalid(v)
+	}
+}
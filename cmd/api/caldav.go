@@ -0,0 +1,848 @@
+// Filename: cmd/api/caldav.go
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/ical"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The WebDAV methods CalDAV clients use besides the HTTP ones
+const (
+	methodPropfind = "PROPFIND"
+	methodReport   = "REPORT"
+)
+
+// The namespaces of the WebDAV, CalDAV and CalendarServer properties
+const (
+	davNS       = "DAV:"
+	calDAVNS    = "urn:ietf:params:xml:ns:caldav"
+	calServerNS = "http://calendarserver.org/ns/"
+)
+
+// The principal of the logged in user is the root and the calendars are
+// below the calendar home. Every list is a calendar, and so is the inbox of
+// todos that are in no list
+const (
+	davRoot      = "/dav/"
+	davCalendars = "/dav/calendars/"
+	davInbox     = "inbox"
+	davWellKnown = "/.well-known/caldav"
+	// WebDAV level 1, sync-collection and CalDAV
+	davCompliance = "1, 3, calendar-access"
+)
+
+// Sync tokens are URIs that end with the sync number of the list
+const syncTokenPrefix = "http://todo.jamesfaber.net/ns/sync/"
+
+// The largest calendar object a client may PUT
+const maxCalendarObjectBytes = 1_048_576
+
+// The largest PROPFIND or REPORT body. A calendar-multiget of a few thousand
+// hrefs still fits
+const maxDAVRequestBytes = 1_048_576
+
+func davName(local string) xml.Name {
+	return xml.Name{Space: davNS, Local: local}
+}
+
+func calName(local string) xml.Name {
+	return xml.Name{Space: calDAVNS, Local: local}
+}
+
+// A davRequest holds what we need from the body of a PROPFIND or REPORT
+type davRequest struct {
+	Name xml.Name
+	// The properties asked for, or nil for all of them
+	Props       []xml.Name
+	Hrefs       []string
+	SyncToken   string
+	CompFilters []string
+}
+
+// The parseDAVRequest() function reads the body of a PROPFIND or REPORT. An
+// empty body asks for all properties
+func parseDAVRequest(body io.Reader) (*davRequest, error) {
+	req := &davRequest{}
+	dec := xml.NewDecoder(body)
+	var stack []xml.Name
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return req, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the body is not valid XML: %w", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch {
+			case len(stack) == 0:
+				req.Name = token.Name
+			case stack[len(stack)-1] == davName("prop"):
+				req.Props = append(req.Props, token.Name)
+			case token.Name == davName("href"), token.Name == davName("sync-token"):
+				var text string
+				if err := dec.DecodeElement(&text, &token); err != nil {
+					return nil, fmt.Errorf("the body is not valid XML: %w", err)
+				}
+				if token.Name.Local == "href" {
+					req.Hrefs = append(req.Hrefs, strings.TrimSpace(text))
+				} else {
+					req.SyncToken = strings.TrimSpace(text)
+				}
+				// DecodeElement() has read the end element too
+				continue
+			case token.Name == calName("comp-filter"):
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "name" {
+						req.CompFilters = append(req.CompFilters, strings.ToUpper(attr.Value))
+					}
+				}
+			}
+			stack = append(stack, token.Name)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// A davProp is a property with its value already written as XML
+type davProp struct {
+	XMLName xml.Name
+	Value   string `xml:",innerxml"`
+}
+
+type davPropList struct {
+	Props []davProp
+}
+
+type davPropstat struct {
+	Prop   davPropList `xml:"prop"`
+	Status string      `xml:"status"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"href"`
+	Status    string        `xml:"status,omitempty"`
+	Propstats []davPropstat `xml:"propstat,omitempty"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"response"`
+	SyncToken string        `xml:"sync-token,omitempty"`
+}
+
+// The xmlText() function escapes text for use in a property value
+func xmlText(s string) string {
+	var b strings.Builder
+	// Writing to a strings.Builder cannot fail
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// The hrefXML() function returns a DAV:href property value
+func hrefXML(path string) string {
+	return `<href xmlns="DAV:">` + xmlText(path) + `</href>`
+}
+
+// The davResponseFor() function answers a request for properties from the
+// ones a resource has. Properties it does not have are reported as not found
+func davResponseFor(href string, req *davRequest, available []davProp) davResponse {
+	found := davPropstat{Status: "HTTP/1.1 200 OK"}
+	missing := davPropstat{Status: "HTTP/1.1 404 Not Found"}
+	if req.Props == nil {
+		// allprop leaves out the calendar data, which can be large
+		for _, prop := range available {
+			if prop.XMLName != calName("calendar-data") {
+				found.Prop.Props = append(found.Prop.Props, prop)
+			}
+		}
+	}
+	for _, name := range req.Props {
+		prop, ok := findProp(available, name)
+		if !ok {
+			missing.Prop.Props = append(missing.Prop.Props, davProp{XMLName: name})
+			continue
+		}
+		found.Prop.Props = append(found.Prop.Props, prop)
+	}
+	response := davResponse{Href: href}
+	if len(found.Prop.Props) > 0 {
+		response.Propstats = append(response.Propstats, found)
+	}
+	if len(missing.Prop.Props) > 0 {
+		response.Propstats = append(response.Propstats, missing)
+	}
+	return response
+}
+
+func findProp(props []davProp, name xml.Name) (davProp, bool) {
+	for _, prop := range props {
+		if prop.XMLName == name {
+			return prop, true
+		}
+	}
+	return davProp{}, false
+}
+
+// A davCalendar is a list seen as a calendar. The inbox has no list
+type davCalendar struct {
+	ListID      *int64
+	Name        string
+	DisplayName string
+}
+
+func (c *davCalendar) href() string {
+	return davCalendars + url.PathEscape(c.Name) + "/"
+}
+
+func (c *davCalendar) objectHref(todo *data.Todo) string {
+	return c.href() + url.PathEscape(todo.ResourceName())
+}
+
+// The syncTokenURI() function turns the sync number of a list into a token
+func syncTokenURI(token int64) string {
+	return syncTokenPrefix + strconv.FormatInt(token, 10)
+}
+
+// The parseSyncToken() function returns the sync number in a token. An empty
+// token asks for a fresh sync
+func parseSyncToken(s string) (int64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	if !strings.HasPrefix(s, syncTokenPrefix) {
+		return 0, false
+	}
+	token, err := strconv.ParseInt(strings.TrimPrefix(s, syncTokenPrefix), 10, 64)
+	return token, err == nil
+}
+
+// The properties of the principal, the calendar home, a calendar and a
+// calendar object
+func principalProps(user *data.User) []davProp {
+	return []davProp{
+		{XMLName: davName("resourcetype"), Value: `<principal xmlns="DAV:"/>`},
+		{XMLName: davName("displayname"), Value: xmlText(user.Name)},
+		{XMLName: davName("current-user-principal"), Value: hrefXML(davRoot)},
+		{XMLName: davName("principal-URL"), Value: hrefXML(davRoot)},
+		{XMLName: calName("calendar-home-set"), Value: hrefXML(davCalendars)},
+	}
+}
+
+func calendarHomeProps() []davProp {
+	return []davProp{
+		{XMLName: davName("resourcetype"), Value: `<collection xmlns="DAV:"/>`},
+		{XMLName: davName("displayname"), Value: "Todos"},
+		{XMLName: davName("current-user-principal"), Value: hrefXML(davRoot)},
+	}
+}
+
+func calendarProps(calendar *davCalendar, token int64) []davProp {
+	reports := ""
+	for _, report := range []xml.Name{calName("calendar-query"), calName("calendar-multiget"), davName("sync-collection")} {
+		reports += fmt.Sprintf(`<supported-report xmlns="DAV:"><report><%s xmlns="%s"/></report></supported-report>`, report.Local, report.Space)
+	}
+	return []davProp{
+		{XMLName: davName("resourcetype"), Value: `<collection xmlns="DAV:"/><calendar xmlns="urn:ietf:params:xml:ns:caldav"/>`},
+		{XMLName: davName("displayname"), Value: xmlText(calendar.DisplayName)},
+		{XMLName: davName("current-user-principal"), Value: hrefXML(davRoot)},
+		{XMLName: davName("supported-report-set"), Value: reports},
+		{XMLName: davName("sync-token"), Value: syncTokenURI(token)},
+		{XMLName: xml.Name{Space: calServerNS, Local: "getctag"}, Value: strconv.FormatInt(token, 10)},
+		{XMLName: calName("supported-calendar-component-set"), Value: `<comp xmlns="urn:ietf:params:xml:ns:caldav" name="VTODO"/>`},
+	}
+}
+
+func calendarObjectProps(todo *data.Todo) ([]davProp, error) {
+	object, err := calendarObject(todo)
+	if err != nil {
+		return nil, err
+	}
+	return []davProp{
+		{XMLName: davName("resourcetype")},
+		{XMLName: davName("getetag"), Value: xmlText(todo.ETag())},
+		{XMLName: davName("getcontenttype"), Value: "text/calendar; charset=utf-8; component=VTODO"},
+		{XMLName: calName("calendar-data"), Value: xmlText(object)},
+	}, nil
+}
+
+// The calendarObject() function returns a todo as a calendar of its own
+func calendarObject(todo *data.Todo) (string, error) {
+	var b strings.Builder
+	cw := newCalendarTodoWriter(&b)
+	// Any error is kept by the writer and returned by Flush()
+	cw.Write(todo)
+	if err := cw.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// The writeMultistatus() method sends a 207 Multi-Status response
+func (app *application) writeMultistatus(w http.ResponseWriter, ms davMultistatus) error {
+	body, err := xml.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+	return nil
+}
+
+// The readDAVCalendar() method returns the calendar named in the URL
+func (app *application) readDAVCalendar(r *http.Request) (*davCalendar, error) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("list")
+	if name == davInbox {
+		return &davCalendar{Name: davInbox, DisplayName: "Inbox"}, nil
+	}
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil || id < 1 {
+		return nil, data.ErrRecordNotFound
+	}
+	list, err := app.models.Lists.ForOrg(app.contextGetMembership(r).OrgID).Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &davCalendar{ListID: &list.ID, Name: name, DisplayName: list.Name}, nil
+}
+
+// The calendarResponse() method reads the sync token of a calendar and
+// answers a request for its properties
+func calendarResponse(todos data.TodoModel, req *davRequest, calendar *davCalendar) (davResponse, error) {
+	token, err := todos.SyncToken(calendar.ListID)
+	if err != nil {
+		return davResponse{}, err
+	}
+	return davResponseFor(calendar.href(), req, calendarProps(calendar, token)), nil
+}
+
+// The objectResponses() function answers a request for the properties of
+// every todo of a calendar
+func objectResponses(req *davRequest, calendar *davCalendar, todos []*data.Todo) ([]davResponse, error) {
+	responses := make([]davResponse, 0, len(todos))
+	for _, todo := range todos {
+		props, err := calendarObjectProps(todo)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, davResponseFor(calendar.objectHref(todo), req, props))
+	}
+	return responses, nil
+}
+
+// The queriesTodos() function checks if the component filters of a
+// calendar-query can match a VTODO. Only VTODOs are kept, so a query for
+// anything else finds nothing. Property filters are not applied
+func queriesTodos(filters []string) bool {
+	for _, name := range filters {
+		if name != "VCALENDAR" && name != "VTODO" {
+			return false
+		}
+	}
+	return true
+}
+
+// The readCalendarObject() method returns the todo named in the URL
+func (app *application) readCalendarObject(r *http.Request, calendar *davCalendar) (*data.Todo, error) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("object")
+	return app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).GetByResourceName(calendar.ListID, name)
+}
+
+// davOptionsHandler for the "OPTIONS /dav/..." endpoints. Clients use it to
+// find out that we speak CalDAV
+func (app *application) davOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", davCompliance)
+	w.Header().Set("Allow", "OPTIONS, GET, PUT, DELETE, PROPFIND, REPORT")
+	w.WriteHeader(http.StatusOK)
+}
+
+// wellKnownCalDAVHandler for the "/.well-known/caldav" endpoint. It sends
+// clients that only know the host name on to the principal
+func (app *application) wellKnownCalDAVHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, davRoot, http.StatusMovedPermanently)
+}
+
+// The readDAVRequest() method reads the XML body of a PROPFIND or REPORT. It
+// sends the error response and returns nil if the body is too large or
+// cannot be read
+func (app *application) readDAVRequest(w http.ResponseWriter, r *http.Request) *davRequest {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDAVRequestBytes)
+	req, err := parseDAVRequest(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return nil
+	}
+	return req
+}
+
+// propfindPrincipalHandler for the "PROPFIND /dav/" endpoint. It points the
+// client to the calendar home
+func (app *application) propfindPrincipalHandler(w http.ResponseWriter, r *http.Request) {
+	req := app.readDAVRequest(w, r)
+	if req == nil {
+		return
+	}
+	user := app.contextGetUser(r)
+	ms := davMultistatus{Responses: []davResponse{davResponseFor(davRoot, req, principalProps(user))}}
+	if err := app.writeMultistatus(w, ms); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// propfindCalendarHomeHandler for the "PROPFIND /dav/calendars/" endpoint.
+// With Depth: 1 it also lists the calendars, the inbox first
+func (app *application) propfindCalendarHomeHandler(w http.ResponseWriter, r *http.Request) {
+	req := app.readDAVRequest(w, r)
+	if req == nil {
+		return
+	}
+	ms := davMultistatus{Responses: []davResponse{davResponseFor(davCalendars, req, calendarHomeProps())}}
+	if r.Header.Get("Depth") != "0" {
+		orgID := app.contextGetMembership(r).OrgID
+		lists, err := app.models.Lists.ForOrg(orgID).GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		calendars := []*davCalendar{{Name: davInbox, DisplayName: "Inbox"}}
+		for _, list := range lists {
+			calendars = append(calendars, &davCalendar{ListID: &list.ID, Name: strconv.FormatInt(list.ID, 10), DisplayName: list.Name})
+		}
+		todos := app.models.Todos.ForOrg(orgID)
+		for _, calendar := range calendars {
+			response, err := calendarResponse(todos, req, calendar)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			ms.Responses = append(ms.Responses, response)
+		}
+	}
+	if err := app.writeMultistatus(w, ms); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// propfindCalendarHandler for the "PROPFIND /dav/calendars/:list/" endpoint.
+// With Depth: 1 it also lists the todos of the calendar
+func (app *application) propfindCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	req := app.readDAVRequest(w, r)
+	if req == nil {
+		return
+	}
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	response, err := calendarResponse(todos, req, calendar)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	ms := davMultistatus{Responses: []davResponse{response}}
+	if r.Header.Get("Depth") != "0" {
+		changes, err := todos.Changes(calendar.ListID, 0)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		responses, err := objectResponses(req, calendar, changes.Changed)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, responses...)
+	}
+	if err := app.writeMultistatus(w, ms); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reportCalendarHandler for the "REPORT /dav/calendars/:list/" endpoint. It
+// runs calendar-query, calendar-multiget and sync-collection reports
+func (app *application) reportCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	req := app.readDAVRequest(w, r)
+	if req == nil {
+		return
+	}
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+
+	var ms davMultistatus
+	switch req.Name {
+	case calName("calendar-query"):
+		if !queriesTodos(req.CompFilters) {
+			break
+		}
+		changes, err := todos.Changes(calendar.ListID, 0)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		ms.Responses, err = objectResponses(req, calendar, changes.Changed)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case calName("calendar-multiget"):
+		for _, href := range req.Hrefs {
+			// Hrefs may be full URLs or paths, and are percent-encoded
+			u, err := url.Parse(href)
+			name := ""
+			if err == nil && strings.HasPrefix(u.Path, calendar.href()) {
+				name = strings.TrimPrefix(u.Path, calendar.href())
+			}
+			todo, err := todos.GetByResourceName(calendar.ListID, name)
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				ms.Responses = append(ms.Responses, davResponse{Href: href, Status: "HTTP/1.1 404 Not Found"})
+				continue
+			case err != nil:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			props, err := calendarObjectProps(todo)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			ms.Responses = append(ms.Responses, davResponseFor(calendar.objectHref(todo), req, props))
+		}
+	case davName("sync-collection"):
+		since, ok := parseSyncToken(req.SyncToken)
+		if !ok {
+			app.davErrorResponse(w, r, http.StatusForbidden, davName("valid-sync-token"))
+			return
+		}
+		changes, err := todos.Changes(calendar.ListID, since)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrInvalidSyncToken):
+				app.davErrorResponse(w, r, http.StatusForbidden, davName("valid-sync-token"))
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		ms.Responses, err = objectResponses(req, calendar, changes.Changed)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, name := range changes.Removed {
+			href := calendar.href() + url.PathEscape(name)
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: "HTTP/1.1 404 Not Found"})
+		}
+		ms.SyncToken = syncTokenURI(changes.Token)
+	default:
+		app.davErrorResponse(w, r, http.StatusForbidden, davName("supported-report"))
+		return
+	}
+	if err := app.writeMultistatus(w, ms); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// propfindCalendarObjectHandler for the "PROPFIND /dav/calendars/:list/:object"
+// endpoint
+func (app *application) propfindCalendarObjectHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	var todo *data.Todo
+	if err == nil {
+		todo, err = app.readCalendarObject(r, calendar)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	req := app.readDAVRequest(w, r)
+	if req == nil {
+		return
+	}
+	props, err := calendarObjectProps(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	ms := davMultistatus{Responses: []davResponse{davResponseFor(calendar.objectHref(todo), req, props)}}
+	if err := app.writeMultistatus(w, ms); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getCalendarObjectHandler for the "GET /dav/calendars/:list/:object"
+// endpoint. It returns the todo as a calendar of its own
+func (app *application) getCalendarObjectHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	var todo *data.Todo
+	if err == nil {
+		todo, err = app.readCalendarObject(r, calendar)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("ETag", todo.ETag())
+	if etagMatches(r.Header.Get("If-None-Match"), todo.ETag(), true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	object, err := calendarObject(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	io.WriteString(w, object)
+}
+
+// putCalendarObjectHandler for the "PUT /dav/calendars/:list/:object"
+// endpoint. It creates or replaces a todo from a calendar with one VTODO
+func (app *application) putCalendarObjectHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCalendarObjectBytes)
+	object, err := ical.Parse(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the calendar object must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.davErrorResponse(w, r, http.StatusForbidden, calName("valid-calendar-data"))
+		}
+		return
+	}
+	if object.Name != "VCALENDAR" {
+		app.davErrorResponse(w, r, http.StatusForbidden, calName("valid-calendar-data"))
+		return
+	}
+	var vtodos []*ical.Component
+	for _, c := range object.Components {
+		switch c.Name {
+		case "VTODO":
+			vtodos = append(vtodos, c)
+		case "VTIMEZONE":
+		default:
+			app.davErrorResponse(w, r, http.StatusForbidden, calName("supported-calendar-component"))
+			return
+		}
+	}
+	// Recurring todos would need more than one VTODO, which we cannot keep
+	if len(vtodos) != 1 {
+		app.davErrorResponse(w, r, http.StatusForbidden, calName("valid-calendar-object-resource"))
+		return
+	}
+
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	todo, err := app.readCalendarObject(r, calendar)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && (todo == nil || !etagMatches(match, todo.ETag(), false)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && todo != nil && etagMatches(noneMatch, todo.ETag(), true) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	created := todo == nil
+	if created {
+		userID := app.contextGetUser(r).ID
+		name := httprouter.ParamsFromContext(r.Context()).ByName("object")
+		todo = &data.Todo{ListID: calendar.ListID, CreatedBy: &userID, DAVName: &name}
+		if uid, ok := vtodos[0].Get("UID"); ok && uid.Value != "" {
+			todo.UID = &uid.Value
+		}
+	}
+	v := validator.New()
+	for key, message := range todoFromVTODO(vtodos[0], todo) {
+		v.AddError(key, message)
+	}
+	if data.ValidateTodo(v, todo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if created {
+		err = todos.Insert(todo)
+	} else {
+		err = todos.Update(todo)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrInvalidList):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The stored todo is not byte for byte what was sent, so no ETag is
+	// returned and the client fetches the todo again
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCalendarObjectHandler for the "DELETE /dav/calendars/:list/:object"
+// endpoint
+func (app *application) deleteCalendarObjectHandler(w http.ResponseWriter, r *http.Request) {
+	calendar, err := app.readDAVCalendar(r)
+	var todo *data.Todo
+	if err == nil {
+		todo, err = app.readCalendarObject(r, calendar)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	match := r.Header.Get("If-Match")
+	if match != "" && !etagMatches(match, todo.ETag(), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	// With If-Match the todo must not change between the check and the delete
+	if match != "" {
+		err = todos.DeleteVersion(todo.ID, todo.Version)
+	} else {
+		err = todos.Delete(todo.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createDAVPasswordHandler for the "POST /v1/users/me/caldav-password"
+// endpoint. It returns the app password CalDAV clients log in with for the
+// organization. Calling it again replaces the password
+func (app *application) createDAVPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.contextGetMembership(r)
+	password, err := app.models.DAVPasswords.New(membership.UserID, membership.OrgID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"caldav_password": password, "url": davRoot}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteDAVPasswordHandler for the "DELETE /v1/users/me/caldav-password"
+// endpoint. CalDAV clients using the password are logged out
+func (app *application) deleteDAVPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.contextGetMembership(r)
+	err := app.models.DAVPasswords.Delete(membership.UserID, membership.OrgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "CalDAV password successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The pruneTombstones() method deletes the tombstones of removed todos once
+// they are older than the retention, every interval until ctx is done
+func (app *application) pruneTombstones(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := app.models.Todos.PruneTombstones(app.config.caldav.tombstoneRetention)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
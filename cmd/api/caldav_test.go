@@ -0,0 +1,263 @@
+// Filename: cmd/api/caldav_test.go
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+)
+
+// A davClient sends CalDAV requests to a test server as one user
+type davClient struct {
+	t        *testing.T
+	server   *httptest.Server
+	email    string
+	password string
+}
+
+func (c *davClient) do(method string, path string, headers map[string]string, body string) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.SetBasicAuth(c.email, c.password)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	res, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res, string(b)
+}
+
+// The parts of a 207 Multi-Status response the tests look at
+type testMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Status    string `xml:"status"`
+		Propstats []struct {
+			Prop struct {
+				ETag string `xml:"getetag"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+	SyncToken string `xml:"sync-token"`
+}
+
+func (c *davClient) multistatus(method string, path string, headers map[string]string, body string) testMultistatus {
+	c.t.Helper()
+	res, b := c.do(method, path, headers, body)
+	if res.StatusCode != http.StatusMultiStatus {
+		c.t.Fatalf("%s %s: got status %d, want 207: %s", method, path, res.StatusCode, b)
+	}
+	var ms testMultistatus
+	if err := xml.Unmarshal([]byte(b), &ms); err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return ms
+}
+
+// The syncCollection() method runs a sync-collection report on the inbox
+// and returns what it reported for each href, with the new sync token
+func (c *davClient) syncCollection(token string) (map[string]string, string) {
+	c.t.Helper()
+	body := `<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + token + `</d:sync-token>` +
+		`<d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`
+	ms := c.multistatus(methodReport, davCalendars+"inbox/", map[string]string{"Content-Type": "application/xml"}, body)
+	seen := make(map[string]string)
+	for _, response := range ms.Responses {
+		switch {
+		case response.Status != "":
+			seen[response.Href] = response.Status
+		case len(response.Propstats) > 0:
+			seen[response.Href] = response.Propstats[0].Prop.ETag
+		}
+	}
+	return seen, ms.SyncToken
+}
+
+// The newDAVTestClient() function starts a server with every route and
+// middleware, and signs a new user up for CalDAV
+func newDAVTestClient(t *testing.T) (*davClient, *application, *data.Membership) {
+	t.Helper()
+	app := newTestApp(t)
+
+	user := &data.User{Name: "Dav Tester", Email: "dav@example.com"}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	org := &data.Organization{Name: user.Name}
+	if err := app.models.Users.Insert(user, org); err != nil {
+		t.Fatal(err)
+	}
+	password, err := app.models.DAVPasswords.New(user.ID, org.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(app.routes())
+	t.Cleanup(server.Close)
+	client := &davClient{t: t, server: server, email: user.Email, password: password.Password}
+	return client, app, &data.Membership{OrgID: org.ID, UserID: user.ID}
+}
+
+const testVTODO = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+	"BEGIN:VTODO\r\nUID:test-uid-1\r\nSUMMARY:Buy milk\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+
+func TestDAVFeedTokenIsRejected(t *testing.T) {
+	client, app, membership := newDAVTestClient(t)
+	feed, err := app.models.CalendarFeeds.New(membership.UserID, membership.OrgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The feed token is read-only, so it must not log a CalDAV client in
+	feedClient := *client
+	feedClient.password = feed.Token
+	res, _ := feedClient.do(methodPropfind, davCalendars, map[string]string{"Depth": "1"}, "")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("PROPFIND with the feed token: got status %d, want 401", res.StatusCode)
+	}
+	res, _ = feedClient.do(http.MethodPut, davCalendars+"inbox/new.ics", map[string]string{"Content-Type": "text/calendar"}, testVTODO)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("PUT with the feed token: got status %d, want 401", res.StatusCode)
+	}
+}
+
+func TestDAVPutPropfindReportDelete(t *testing.T) {
+	client, _, _ := newDAVTestClient(t)
+	href := davCalendars + "inbox/new-todo.ics"
+
+	_, token := client.syncCollection("")
+
+	res, body := client.do(http.MethodPut, href, map[string]string{"Content-Type": "text/calendar", "If-None-Match": "*"}, testVTODO)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: got status %d, want 201: %s", res.StatusCode, body)
+	}
+	// The todo exists now, so creating it again must fail
+	res, _ = client.do(http.MethodPut, href, map[string]string{"Content-Type": "text/calendar", "If-None-Match": "*"}, testVTODO)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("second PUT with If-None-Match: got status %d, want 412", res.StatusCode)
+	}
+
+	ms := client.multistatus(methodPropfind, davCalendars+"inbox/", map[string]string{"Depth": "1", "Content-Type": "application/xml"},
+		`<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`)
+	var etag string
+	for _, response := range ms.Responses {
+		if response.Href == href && len(response.Propstats) > 0 {
+			etag = response.Propstats[0].Prop.ETag
+		}
+	}
+	if etag == "" {
+		t.Fatalf("PROPFIND did not list %s with an etag", href)
+	}
+
+	seen, token := client.syncCollection(token)
+	if seen[href] != etag {
+		t.Errorf("sync after PUT: got %q for %s, want etag %s", seen[href], href, etag)
+	}
+
+	res, _ = client.do(http.MethodDelete, href, map[string]string{"If-Match": `"0-0"`}, "")
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale If-Match: got status %d, want 412", res.StatusCode)
+	}
+	res, body = client.do(http.MethodDelete, href, map[string]string{"If-Match": etag}, "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: got status %d, want 204: %s", res.StatusCode, body)
+	}
+
+	seen, _ = client.syncCollection(token)
+	if !strings.Contains(seen[href], "404") {
+		t.Errorf("sync after DELETE: got %q for %s, want a 404 status", seen[href], href)
+	}
+}
+
+func TestDAVSyncTokenWaitsForOpenTransactions(t *testing.T) {
+	client, app, membership := newDAVTestClient(t)
+
+	// A todo whose transaction is still open when the token is handed out
+	tx, err := app.models.Todos.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRow(`INSERT INTO todo (org_id, name, task) VALUES ($1, 'Late', 'Late') RETURNING id`, membership.OrgID).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, token := client.syncCollection("")
+	// A todo that commits first, after the open one took its place
+	res, body := client.do(http.MethodPut, davCalendars+"inbox/early.ics", map[string]string{"Content-Type": "text/calendar"}, testVTODO)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: got status %d, want 201: %s", res.StatusCode, body)
+	}
+	_, token = client.syncCollection(token)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	seen, _ := client.syncCollection(token)
+	href := davCalendars + fmt.Sprintf("inbox/todo-%d.ics", id)
+	if _, ok := seen[href]; !ok {
+		t.Errorf("the todo committed after the token was handed out was not synced, got %v", seen)
+	}
+}
+
+func TestDAVSyncTokenBeforePrunedTombstones(t *testing.T) {
+	client, app, _ := newDAVTestClient(t)
+	href := davCalendars + "inbox/pruned.ics"
+	res, body := client.do(http.MethodPut, href, map[string]string{"Content-Type": "text/calendar"}, testVTODO)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT: got status %d, want 201: %s", res.StatusCode, body)
+	}
+	_, token := client.syncCollection("")
+	res, body = client.do(http.MethodDelete, href, nil, "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: got status %d, want 204: %s", res.StatusCode, body)
+	}
+
+	// A negative retention prunes every tombstone there is
+	pruned, err := app.models.Todos.PruneTombstones(-time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("pruned %d tombstones, want 1", pruned)
+	}
+	// The removal is gone, so the client must be told to sync afresh
+	sync := `<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + token + `</d:sync-token>` +
+		`<d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`
+	res, body = client.do(methodReport, davCalendars+"inbox/", map[string]string{"Content-Type": "application/xml"}, sync)
+	if res.StatusCode != http.StatusForbidden || !strings.Contains(body, "valid-sync-token") {
+		t.Errorf("sync with a token from before the pruning: got status %d, want 403 valid-sync-token: %s", res.StatusCode, body)
+	}
+	if seen, _ := client.syncCollection(""); len(seen) != 0 {
+		t.Errorf("fresh sync after the pruning: got %v, want no todos", seen)
+	}
+}
+
+func TestDAVRequestBodyLimit(t *testing.T) {
+	client, _, _ := newDAVTestClient(t)
+	body := `<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop>` +
+		strings.Repeat("<!-- padding -->", maxDAVRequestBytes/16+1) + `</d:propfind>`
+	res, _ := client.do(methodPropfind, davCalendars+"inbox/", map[string]string{"Depth": "1", "Content-Type": "application/xml"}, body)
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("PROPFIND with a body over the limit: got status %d, want 413", res.StatusCode)
+	}
+}
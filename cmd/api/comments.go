@@ -0,0 +1,80 @@
+// Filename: cmd/api/comments.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// createCommentHandler for the "POST /v2/todos/:id/comments" endpoint
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Body string `json:"body"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	comment := &data.Comment{TodoID: id, UserID: &user.ID, Body: input.Body}
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Only todos of the request's organization can be commented on
+	err = app.models.Comments.ForOrg(app.contextGetMembership(r).OrgID).Insert(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listCommentsHandler for the "GET /v2/todos/:id/comments" endpoint
+func (app *application) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	orgID := app.contextGetMembership(r).OrgID
+	// A todo without comments is told apart from a todo that does not exist
+	_, err = app.models.Todos.ForOrg(orgID).WithProjection(data.Projection{Fields: []string{"id"}}).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	comments, err := app.models.Comments.ForOrg(orgID).GetAll(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"comments": comments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,222 @@
+// Filename: cmd/api/compress.go
+
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The content codings we compress with, in order of preference
+var compressEncodings = []string{"gzip", "deflate"}
+
+// Compressors are reused since each one allocates a large window
+var (
+	gzipPool  = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	flatePool = sync.Pool{New: func() interface{} {
+		fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return fw
+	}}
+)
+
+// The negotiateEncoding() function returns the content coding that the
+// Accept-Encoding header prefers, or "" to send the response as it is. A
+// coding that is not listed gets the q value of "*", if there is one
+func negotiateEncoding(header string) string {
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		qs[coding] = q
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range compressEncodings {
+		q, ok := qs[coding]
+		if !ok {
+			q = qs["*"]
+		}
+		// Earlier codings win ties
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// A compressWriter holds back the start of a response until it is sure the
+// response is big enough to be worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+	status   int
+	buf      []byte
+	// Set once the response has started, compressed when cw is not nil
+	started bool
+	cw      io.WriteCloser
+}
+
+// The start() method sends the status and the held back bytes, compressed
+// when compress is true and the handler did not encode the body itself
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	h := w.ResponseWriter.Header()
+	if compress && h.Get("Content-Encoding") == "" {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		switch w.encoding {
+		case "gzip":
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.cw = gw
+		case "deflate":
+			fw := flatePool.Get().(*flate.Writer)
+			fw.Reset(w.ResponseWriter)
+			w.cw = fw
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	// Informational responses go out at once and do not end the headers
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	// These responses have no body
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.started {
+		if w.cw != nil {
+			return w.cw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minBytes {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush() starts the response even if it is still small, since a handler
+// that flushes is streaming and the rest is likely to follow
+func (w *compressWriter) Flush() {
+	if w.status != 0 && !w.started {
+		w.start(true)
+	}
+	if flusher, ok := w.cw.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// The close() method ends the response. One that stayed below the minimum
+// size is sent as it is
+func (w *compressWriter) close() error {
+	if w.status != 0 && !w.started {
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.cw == nil {
+		return nil
+	}
+	err := w.cw.Close()
+	switch cw := w.cw.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(cw)
+	case *flate.Writer:
+		flatePool.Put(cw)
+	}
+	w.cw = nil
+	return err
+}
+
+// The compress() middleware compresses responses of at least the minimum size
+// with gzip or deflate, as the Accept-Encoding header allows. Entity tags are
+// kept as they are: they name a version of a todo, not the bytes sent, and
+// If-Match must keep working for clients that read a compressed response
+func (app *application) compress(next http.Handler) http.Handler {
+	if !app.config.compress.enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response differs by Accept-Encoding even when it is not
+		// compressed, so caches must keep them apart
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minBytes: app.config.compress.minBytes}
+		defer func() {
+			if err := recover(); err != nil {
+				// Once the response has started, an error written by
+				// recoverPanic() would land in the middle of the compressed
+				// stream, so log the panic here and abort the response. Until
+				// then the held back bytes are just dropped
+				if cw.started && err != http.ErrAbortHandler {
+					app.logError(r, fmt.Errorf("panic: %v\n%s", err, debug.Stack()))
+					panic(http.ErrAbortHandler)
+				}
+				panic(err)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+		// Not deferred: after a panic that aborts the response the client
+		// must not get a body that looks complete
+		if err := cw.close(); err != nil {
+			app.logError(r, err)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Filename: cmd/api/context.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"todo.jamesfaber.net/internal/data"
+)
+
+// Define a custom type for our context keys so they cannot collide with
+// keys set by other packages
+type contextKey string
+
+const (
+	userContextKey       = contextKey("user")
+	sessionContextKey    = contextKey("session")
+	membershipContextKey = contextKey("membership")
+	requestContextKey    = contextKey("request")
+	idempotencyKey       = contextKey("idempotency")
+)
+
+// A requestInfo describes a request in the logs. Every copy of the request
+// shares it, so the user that authenticate() finds also shows up in the
+// access log that is written further out
+type requestInfo struct {
+	ID     string
+	UserID int64
+	// The pattern of the route that matched, if any
+	Route string
+}
+
+// The contextSetUser() method returns a copy of the request with the user added
+// to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info := app.contextGetRequestInfo(r); info != nil && !user.IsAnonymous() {
+		info.UserID = user.ID
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() method retrieves the user from the request context
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// The contextSetSession() method returns a copy of the request with the id of
+// the session behind its access token added to its context
+func (app *application) contextSetSession(r *http.Request, session string) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// The contextGetSession() method retrieves the session id from the request
+// context. It is empty for anonymous users
+func (app *application) contextGetSession(r *http.Request) string {
+	session, _ := r.Context().Value(sessionContextKey).(string)
+	return session
+}
+
+// The contextSetMembership() method returns a copy of the request with the
+// user's membership of the organization being worked on added to its context
+func (app *application) contextSetMembership(r *http.Request, membership *data.Membership) *http.Request {
+	ctx := context.WithValue(r.Context(), membershipContextKey, membership)
+	return r.WithContext(ctx)
+}
+
+// The contextGetMembership() method retrieves the membership from the request
+// context
+func (app *application) contextGetMembership(r *http.Request) *data.Membership {
+	membership, ok := r.Context().Value(membershipContextKey).(*data.Membership)
+	if !ok {
+		panic("missing membership value in request context")
+	}
+	return membership
+}
+
+// The contextSetRequestInfo() method returns a copy of the request with its
+// log details added to its context
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestInfo() method retrieves the log details of the request.
+// It is nil for requests that did not pass through requestID()
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestContextKey).(*requestInfo)
+	return info
+}
+
+// The contextSetIdempotencyClaim() method returns a copy of the request with
+// the claim on its Idempotency-Key added to its context
+func (app *application) contextSetIdempotencyClaim(r *http.Request, claim *data.IdempotencyClaim) *http.Request {
+	ctx := context.WithValue(r.Context(), idempotencyKey, claim)
+	return r.WithContext(ctx)
+}
+
+// The contextGetIdempotencyClaim() method retrieves the claim from the request
+// context. It is nil for requests without an Idempotency-Key
+func (app *application) contextGetIdempotencyClaim(r *http.Request) *data.IdempotencyClaim {
+	claim, _ := r.Context().Value(idempotencyKey).(*data.IdempotencyClaim)
+	return claim
+}
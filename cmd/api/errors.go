@@ -2,19 +2,32 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
+// The logError() method logs an error along with the request it happened in
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	properties := map[string]string{
+		"request_method": r.Method,
+		"request_url":    redactedURI(r.URL),
+	}
+	if info := app.contextGetRequestInfo(r); info != nil {
+		properties["request_id"] = info.ID
+		if info.UserID != 0 {
+			properties["user_id"] = strconv.FormatInt(info.UserID, 10)
+		}
+	}
+	app.logger.PrintError(err, properties)
 }
 
 // we want  to send JSON.Formatted error message
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	//create json response
 	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -65,3 +78,73 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+// Too many phone codes error
+func (app *application) tooManyPhoneCodesResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many verification codes requested, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// Duplicate email error
+func (app *application) duplicateEmailResponse(w http.ResponseWriter, r *http.Request) {
+	app.failedValidationResponse(w, r, map[string]string{"email": "a user with this email address already exists"})
+}
+
+// Invalid credentials error
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Invalid token error
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Basic authentication required error
+func (app *application) basicAuthenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="todo", charset="UTF-8"`)
+	message := "log in with your email address and a CalDAV app password"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Authentication required error
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Not permitted error
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// Precondition failed error
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// Unsupported media type error
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, mediaType string) {
+	w.Header().Set("Accept-Patch", "application/json, application/merge-patch+json, application/json-patch+json")
+	message := fmt.Sprintf("the %s content type is not supported for this resource", mediaType)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// Failed patch test error
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	message := fmt.Sprintf("the patch was not applied: %s", err)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// WebDAV precondition error. The body names the precondition that failed
+func (app *application) davErrorResponse(w http.ResponseWriter, r *http.Request, status int, condition xml.Name) {
+	body := fmt.Sprintf(`%s<error xmlns="DAV:"><%s xmlns="%s"/></error>`, xml.Header, condition.Local, condition.Space)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
@@ -0,0 +1,178 @@
+// Filename: cmd/api/export.go
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The media types todos can be written as besides JSON
+const (
+	csvMediaType      = "text/csv"
+	ndjsonMediaType   = "application/x-ndjson"
+	todoTxtMediaType  = "text/plain"
+	calendarMediaType = "text/calendar"
+)
+
+// A todoWriter writes todos one at a time in an export format
+type todoWriter interface {
+	Write(todo *data.Todo) error
+	Flush() error
+}
+
+// The newTodoWriter() function returns a todoWriter for the media type
+func newTodoWriter(w io.Writer, mediaType string) todoWriter {
+	switch mediaType {
+	case csvMediaType:
+		return newCSVTodoWriter(w)
+	case todoTxtMediaType:
+		return &todoTxtWriter{w: w}
+	case calendarMediaType:
+		return newCalendarTodoWriter(w)
+	default:
+		return &ndjsonTodoWriter{enc: json.NewEncoder(w)}
+	}
+}
+
+// A csvTodoWriter writes a header row and then one row per todo
+type csvTodoWriter struct {
+	w *csv.Writer
+}
+
+func newCSVTodoWriter(w io.Writer) *csvTodoWriter {
+	cw := csv.NewWriter(w)
+	// Any error is kept by the csv.Writer and returned by Flush()
+	cw.Write([]string{"id", "list_id", "name", "task", "due", "version"})
+	return &csvTodoWriter{w: cw}
+}
+
+func (cw *csvTodoWriter) Write(todo *data.Todo) error {
+	listID, due := "", ""
+	if todo.ListID != nil {
+		listID = strconv.FormatInt(*todo.ListID, 10)
+	}
+	if todo.Due != nil {
+		due = todo.Due.Format(time.RFC3339)
+	}
+	return cw.w.Write([]string{
+		strconv.FormatInt(todo.ID, 10),
+		listID,
+		todo.Name,
+		todo.Task,
+		due,
+		strconv.FormatInt(int64(todo.Version), 10),
+	})
+}
+
+func (cw *csvTodoWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// An ndjsonTodoWriter writes every todo as a JSON object on its own line
+type ndjsonTodoWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonTodoWriter) Write(todo *data.Todo) error {
+	return nw.enc.Encode(todo)
+}
+
+func (nw *ndjsonTodoWriter) Flush() error {
+	return nil
+}
+
+// The streamTodos() method writes the todos that each() hands over in the
+// given media type. The response is only started with the first todo, so an
+// error before that still gets a proper error response. An error after that
+// aborts the response so the client can tell it is incomplete
+func (app *application) streamTodos(w http.ResponseWriter, r *http.Request, mediaType string, headers http.Header, each func(fn func(*data.Todo) error) error) {
+	var tw todoWriter
+	start := func() {
+		// Added to, not replaced, so a Link from the middleware is kept
+		for key, value := range headers {
+			w.Header()[key] = append(w.Header()[key], value...)
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusOK)
+		tw = newTodoWriter(w, mediaType)
+	}
+	err := each(func(todo *data.Todo) error {
+		if tw == nil {
+			start()
+		}
+		return tw.Write(todo)
+	})
+	switch {
+	case err != nil && tw == nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case err != nil:
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+	// An empty listing still gets its headers and CSV header row
+	if tw == nil {
+		start()
+	}
+	if err := tw.Flush(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// exportTodoInfoHandler for the "GET /v1/todoInfo/export" endpoint. It writes
+// every todo that matches the search, without pages, as NDJSON or as CSV
+// depending on the Accept header
+func (app *application) exportTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := app.negotiate(w, r, ndjsonMediaType, csvMediaType)
+	filename := "todos.ndjson"
+	if mediaType == csvMediaType {
+		filename = "todos.csv"
+	}
+	app.exportTodos(w, r, mediaType, filename, data.Projection{})
+}
+
+// The exportTodos() method streams every todo that matches the search in the
+// query string, as a file download if there is a filename
+func (app *application) exportTodos(w http.ResponseWriter, r *http.Request, mediaType string, filename string, projection data.Projection) {
+	qs := r.URL.Query()
+	name := app.readString(qs, "name", "")
+	task := app.readString(qs, "task", "")
+	filters := data.Filters{
+		Sort:     app.readString(qs, "sort", "id"),
+		SortList: []string{"id", "name", "task", "-id", "-name", "-task"},
+	}
+	v := validator.New()
+	v.Check(validator.In(filters.Sort, filters.SortList...), "sort", "invalid sort value")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Without a filename the todos are shown rather than downloaded
+	headers := make(http.Header)
+	if filename != "" {
+		headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	}
+
+	// A large export can take longer than the server's WriteTimeout, so the
+	// response gets as long as the query. Small exports still work where
+	// the deadline cannot be moved
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(data.ExportTimeout))
+	if err != nil {
+		app.logError(r, err)
+	}
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).WithProjection(projection)
+	app.streamTodos(w, r, mediaType, headers, func(fn func(*data.Todo) error) error {
+		return todos.Export(name, task, filters, fn)
+	})
+}
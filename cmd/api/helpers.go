@@ -3,14 +3,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"todo.jamesfaber.net/internal/validator"
@@ -30,9 +37,92 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// The clientIP() method returns the IP address the request came from
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Query parameters that carry secrets, such as the token of a calendar feed
+// URL. Their values are kept out of the logs
+var secretQueryParams = []string{"token", "access_token", "refresh_token", "password", "code"}
+
+// The redactedURI() function returns the path and query of a URL for the logs,
+// with the values of secret query parameters replaced
+func redactedURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		key, _, found := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); found && err == nil && validator.In(strings.ToLower(name), secretQueryParams...) {
+			params[i] = key + "=REDACTED"
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.RequestURI()
+}
+
+// The negotiate() method returns the offered media type that the Accept header
+// prefers. Without an Accept header, or when nothing offered is acceptable, it
+// falls back to the first offer. The response then depends on the Accept
+// header, which caches are told with Vary
+func (app *application) negotiate(w http.ResponseWriter, r *http.Request, offers ...string) string {
+	w.Header().Add("Vary", "Accept")
+	best, bestQ := offers[0], 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		// Earlier media types win ties
+		if q <= bestQ {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaType == offer || mediaType == "*/*" ||
+				strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")) {
+				best, bestQ = offer, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+// The compactJSON() method reports whether JSON is sent without indentation.
+// ?compact=true or ?compact=false overrides the -json-compact setting
+func (app *application) compactJSON(r *http.Request) bool {
+	if compact, err := strconv.ParseBool(r.URL.Query().Get("compact")); err == nil {
+		return compact
+	}
+	return app.config.json.compact
+}
+
+// The marshalJSON() function encodes v, indented after the prefix unless
+// compact is set
+func marshalJSON(v interface{}, compact bool, prefix string) ([]byte, error) {
+	if compact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, prefix, "\t")
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
 	//Convert our map into a JSON object
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := marshalJSON(data, app.compactJSON(r), "")
 	if err != nil {
 		return err
 	}
@@ -50,6 +140,83 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// The writeJSONList() method writes an envelope in which key holds a list.
+// The rest of the envelope is encoded up front so an error there still gets
+// a proper error response, but the items are encoded one at a time straight
+// to the client rather than into one buffer. An error after the first byte
+// aborts the response so the client can tell it is incomplete
+func (app *application) writeJSONList(w http.ResponseWriter, r *http.Request, status int, key string, items []interface{}, rest envelope, headers http.Header) error {
+	compact := app.compactJSON(r)
+	keys := []string{key}
+	encoded := make(map[string][]byte, len(rest))
+	for k, v := range rest {
+		js, err := marshalJSON(v, compact, "\t")
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		encoded[k] = js
+	}
+	// Keys are sorted as json.Marshal() sorts them
+	sort.Strings(keys)
+	for k, value := range headers {
+		w.Header()[k] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	bw := bufio.NewWriter(w)
+	newline, colon := "\n", ": "
+	if compact {
+		newline, colon = "", ":"
+	}
+	bw.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		name, _ := json.Marshal(k)
+		bw.WriteString(newline + indent(compact, 1))
+		bw.Write(name)
+		bw.WriteString(colon)
+		if k != key {
+			bw.Write(encoded[k])
+			continue
+		}
+		bw.WriteString("[")
+		for j, item := range items {
+			js, err := marshalJSON(item, compact, indent(compact, 2))
+			if err != nil {
+				app.logError(r, err)
+				panic(http.ErrAbortHandler)
+			}
+			if j > 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString(newline + indent(compact, 2))
+			bw.Write(js)
+		}
+		if len(items) > 0 {
+			bw.WriteString(newline + indent(compact, 1))
+		}
+		bw.WriteString("]")
+	}
+	// Add a newline to make viewing on the terminal easier
+	bw.WriteString(newline + "}\n")
+	if err := bw.Flush(); err != nil {
+		app.logError(r, err)
+	}
+	return nil
+}
+
+// The indent() function returns the indentation of a nesting depth
+func indent(compact bool, depth int) string {
+	if compact {
+		return ""
+	}
+	return strings.Repeat("\t", depth)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytereader() to limit the size of the request body to
 	// 1MB 2^20
@@ -107,6 +274,29 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// The etagMatches() function checks an If-Match or If-None-Match header value
+// against an entity tag. If-Match uses the strong comparison, so weak tags in
+// the header never match, while If-None-Match uses the weak comparison
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(etag, "W/") {
+			// A weak tag never matches in the strong comparison
+			return false
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // The readString() method returns a string value from the query parameters
 // string or it returns a default value if no matching key is found
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -130,6 +320,21 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(value, ",")
 }
 
+// The readBool() method converts a string value from the query string to a
+// bool. If the value is not a bool a validation error is added
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+	return boolValue
+}
+
 // The readInt() method converts a string value from the query string to an integer value
 // If the value cannot be converted to an integer then a validation error is added to
 // the validations errors map.
@@ -146,3 +351,30 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return intValue
 }
+
+// The background() method runs fn in a goroutine that the server waits for
+// during a graceful shutdown. A panic in fn is logged instead of crashing the
+// application
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("panic in background task: %v", err), map[string]string{"trace": string(debug.Stack())})
+			}
+		}()
+		fn()
+	}()
+}
+
+// The startJob() method runs job in a goroutine that the server waits for
+// during a graceful shutdown. The job gets the context that is cancelled when
+// shutdown begins and must return soon after that
+func (app *application) startJob(job func(ctx context.Context, interval time.Duration), interval time.Duration) {
+	app.jobs.Add(1)
+	go func() {
+		defer app.jobs.Done()
+		job(app.shutdown, interval)
+	}()
+}
@@ -0,0 +1,112 @@
+// Filename: cmd/api/helpers_test.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/jsonlog"
+	"todo.jamesfaber.net/internal/jwt"
+)
+
+// The handler tests need PostgreSQL. TODO_TEST_DB_DSN names a database they
+// may create schemas in. Each test migrates a schema of its own and drops it
+// when done
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TODO_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TODO_TEST_DB_DSN is not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// Every connection of the pool works in the test's schema
+	searchPath := schema + ",public"
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", searchPath)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + searchPath
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(migration)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// The newTestApp() function returns an application on a database of its own
+// with the settings the tests rely on. Rate limits are off and the periodic
+// jobs are stopped when the test ends
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+	db := openTestDB(t)
+	keys, err := jwt.RandomKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	cfg.jwt.issuer = "todo.test"
+	cfg.jwt.audience = "todo.test/api"
+	cfg.jwt.accessTTL = 15 * time.Minute
+	cfg.jwt.refreshTTL = time.Hour
+	shutdown, stopJobs := context.WithCancel(context.Background())
+	app := &application{
+		config:   cfg,
+		logger:   jsonlog.New(io.Discard, jsonlog.LevelOff),
+		metrics:  newAppMetrics(db),
+		models:   data.NewModels(db),
+		jwtKeys:  keys,
+		sessions: newSessionTracker(),
+		shutdown: shutdown,
+		stopJobs: stopJobs,
+	}
+	t.Cleanup(func() {
+		app.stopJobs()
+		app.jobs.Wait()
+	})
+	return app
+}
@@ -0,0 +1,373 @@
+// Filename: cmd/api/ical.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/ical"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// Todos keep the same UID in every feed, so calendar apps can match them up
+var todoUIDRX = regexp.MustCompile(`^todo-(\d+)@todo\.jamesfaber\.net$`)
+
+// The todoUID() function returns the iCalendar UID of a todo
+func todoUID(id int64) string {
+	return fmt.Sprintf("todo-%d@todo.jamesfaber.net", id)
+}
+
+// A calendarTodoWriter writes every todo as a VTODO of one VCALENDAR
+type calendarTodoWriter struct {
+	w     *ical.Writer
+	stamp string
+}
+
+func newCalendarTodoWriter(w io.Writer) *calendarTodoWriter {
+	cw := &calendarTodoWriter{w: ical.NewWriter(w), stamp: time.Now().UTC().Format(ical.DateTimeLayout)}
+	// Any error is kept by the ical.Writer and returned by Flush()
+	cw.w.WriteLine("BEGIN", "VCALENDAR")
+	cw.w.WriteLine("VERSION", "2.0")
+	cw.w.WriteLine("PRODID", "-//todo.jamesfaber.net//Todo API//EN")
+	return cw
+}
+
+func (cw *calendarTodoWriter) Write(todo *data.Todo) error {
+	cw.w.WriteLine("BEGIN", "VTODO")
+	// Todos created by a CalDAV client keep the UID it gave them
+	uid := todoUID(todo.ID)
+	if todo.UID != nil {
+		uid = *todo.UID
+	}
+	cw.w.WriteLine("UID", uid)
+	cw.w.WriteLine("DTSTAMP", cw.stamp)
+	if !todo.CreatedAt.IsZero() {
+		cw.w.WriteLine("CREATED", todo.CreatedAt.UTC().Format(ical.DateTimeLayout))
+	}
+	cw.w.WriteLine("SUMMARY", ical.EscapeText(todo.Name))
+	cw.w.WriteLine("DESCRIPTION", ical.EscapeText(todo.Task))
+	if todo.Due != nil {
+		cw.w.WriteLine("DUE", todo.Due.UTC().Format(ical.DateTimeLayout))
+	}
+	if todo.CompletedAt != nil {
+		cw.w.WriteLine("STATUS", "COMPLETED")
+		cw.w.WriteLine("COMPLETED", todo.CompletedAt.UTC().Format(ical.DateTimeLayout))
+	} else {
+		cw.w.WriteLine("STATUS", "NEEDS-ACTION")
+	}
+	// iCalendar priorities run from 1, the highest, to 9. A is 1 and
+	// everything after I is 9
+	if todo.Priority != "" {
+		priority := int(todo.Priority[0]-'A') + 1
+		if priority > 9 {
+			priority = 9
+		}
+		cw.w.WriteLine("PRIORITY", strconv.Itoa(priority))
+	}
+	// SEQUENCE starts at 0 while our versions start at 1
+	cw.w.WriteLine("SEQUENCE", strconv.Itoa(int(todo.Version)-1))
+	cw.w.WriteLine("END", "VTODO")
+	return cw.w.Err()
+}
+
+func (cw *calendarTodoWriter) Flush() error {
+	cw.w.WriteLine("END", "VCALENDAR")
+	return cw.w.Err()
+}
+
+// A calendarImportReader reads the VTODOs of a calendar one at a time. A
+// VTODO whose UID is one of our todos, or the UID a todo was added with,
+// updates that todo. All others are added and keep their UID
+type calendarImportReader struct {
+	d *ical.Decoder
+	// The first component, read ahead to check the calendar
+	next *ical.Component
+	done bool
+	// The todos of the organization, inside the import's transaction once
+	// the rows are written
+	model data.TodoModel
+	// The line of the VTODO each UID was first seen on. The rows of a batch
+	// are only written after they are all read, so a UID given twice would
+	// otherwise add two todos
+	uids map[string]int
+}
+
+// The newCalendarImportReader() function reads up to the first component of a
+// calendar, so a body that is no calendar at all is turned away as a whole
+func newCalendarImportReader(r io.Reader, model data.TodoModel) (*calendarImportReader, error) {
+	cr := &calendarImportReader{d: ical.NewDecoder(r), model: model, uids: make(map[string]int)}
+	next, err := cr.d.Next()
+	switch {
+	case err == nil:
+		cr.next = next
+	case errors.Is(err, io.EOF):
+		cr.done = true
+	// A calendar broken further down is read up to the break by Next()
+	case errors.Is(err, ical.ErrInvalidCalendar) && cr.d.Root() != nil && cr.d.Root().Name == "VCALENDAR":
+	case errors.Is(err, ical.ErrInvalidCalendar):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("the calendar could not be read: %w", err)
+	}
+	if cr.d.Root().Name != "VCALENDAR" {
+		return nil, errors.New("the body must be a VCALENDAR")
+	}
+	return cr, nil
+}
+
+func (cr *calendarImportReader) useTx(tx data.TodoModel) {
+	cr.model = tx
+}
+
+func (cr *calendarImportReader) Next() (importRow, error) {
+	vtodo := cr.next
+	cr.next = nil
+	for vtodo == nil || vtodo.Name != "VTODO" {
+		if cr.done {
+			return importRow{}, io.EOF
+		}
+		c, err := cr.d.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			cr.done = true
+			continue
+		// Nothing after a broken line can be read, so it is the last row
+		case errors.Is(err, ical.ErrInvalidCalendar):
+			cr.done = true
+			return importRow{line: cr.d.Line(), errors: map[string]string{"calendar": err.Error()}}, nil
+		case err != nil:
+			return importRow{}, err
+		}
+		vtodo = c
+	}
+	row := importRow{line: vtodo.Line, todo: &data.Todo{}, errors: make(map[string]string)}
+
+	uid, ok := vtodo.Get("UID")
+	if first, seen := cr.uids[uid.Value]; ok && seen {
+		row.errors["uid"] = fmt.Sprintf("is also used by the VTODO on line %d", first)
+	} else if ok && uid.Value != "" {
+		cr.uids[uid.Value] = vtodo.Line
+		todo, err := cr.findTodo(uid.Value)
+		switch {
+		case err == nil:
+			row.todo = todo
+			// A VTODO edited from an older copy would undo newer changes
+			if sequence, ok := vtodo.Get("SEQUENCE"); ok {
+				if n, err := strconv.Atoi(sequence.Value); err == nil && n < int(todo.Version)-1 {
+					row.errors["sequence"] = "the todo has changed since this VTODO was exported"
+				}
+			}
+		// A todo that was deleted, or belongs to another organization, is
+		// added again. It keeps the UID so importing the file again updates it
+		case errors.Is(err, data.ErrRecordNotFound):
+			row.todo.UID = &uid.Value
+		default:
+			return importRow{}, err
+		}
+	}
+
+	for key, message := range todoFromVTODO(vtodo, row.todo) {
+		row.errors[key] = message
+	}
+	return row, nil
+}
+
+// The findTodo() method returns the todo a VTODO's UID belongs to. Our own
+// UIDs carry the todo's id, other UIDs are the ones todos were added with
+func (cr *calendarImportReader) findTodo(uid string) (*data.Todo, error) {
+	if match := todoUIDRX.FindStringSubmatch(uid); match != nil {
+		id, _ := strconv.ParseInt(match[1], 10, 64)
+		todo, err := cr.model.Get(id)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return todo, err
+		}
+	}
+	return cr.model.GetByUID(uid)
+}
+
+// The todoFromVTODO() function sets the fields of a todo from a VTODO and
+// returns the problems it found
+func todoFromVTODO(vtodo *ical.Component, todo *data.Todo) map[string]string {
+	problems := make(map[string]string)
+	summary, ok := vtodo.Get("SUMMARY")
+	if !ok {
+		problems["summary"] = "must be provided"
+	}
+	todo.Name = summary.Text()
+	todo.Task = todo.Name
+	if description, ok := vtodo.Get("DESCRIPTION"); ok && description.Text() != "" {
+		todo.Task = description.Text()
+	}
+
+	todo.Due = nil
+	if due, ok := vtodo.Get("DUE"); ok {
+		t, err := due.Time()
+		if err != nil {
+			problems["due"] = "must be a DATE or DATE-TIME"
+		}
+		todo.Due = &t
+	}
+
+	todo.CompletedAt = nil
+	if status, _ := vtodo.Get("STATUS"); status.Value == "COMPLETED" {
+		completedAt := time.Now().UTC()
+		if completed, ok := vtodo.Get("COMPLETED"); ok {
+			t, err := completed.Time()
+			if err != nil {
+				problems["completed"] = "must be a DATE-TIME"
+			}
+			completedAt = t
+		}
+		todo.CompletedAt = &completedAt
+	}
+
+	todo.Priority = ""
+	if priority, ok := vtodo.Get("PRIORITY"); ok {
+		n, err := strconv.Atoi(priority.Value)
+		switch {
+		case err != nil || n < 0 || n > 9:
+			problems["priority"] = "must be an integer between 0 and 9"
+		// 0 means the priority is not set
+		case n > 0:
+			todo.Priority = string(rune('A' + n - 1))
+		}
+	}
+	return problems
+}
+
+// The writeCalendarRows() function updates the todos of a batch that already
+// exist and inserts the others
+func writeCalendarRows(tx data.TodoModel, rows []importRow) error {
+	for _, row := range rows {
+		var err error
+		if row.todo.ID != 0 {
+			err = tx.Update(row.todo)
+		} else {
+			err = tx.Insert(row.todo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calendarFeedHandler for the "GET /v1/todoInfo.ics" endpoint. Calendar apps
+// cannot send an access token, so the feed is found through the secret token
+// in its URL and shows the todos of that feed's organization
+func (app *application) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	v := validator.New()
+	if data.ValidateCalendarFeedToken(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An unknown token gets a 404 so feed URLs cannot be guessed
+	feed, err := app.models.CalendarFeeds.GetForToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The feed stops working once its user leaves the organization
+	membership, err := app.models.Organizations.GetMembership(feed.OrgID, feed.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.models.Users.Get(feed.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetMembership(r, membership)
+	app.exportTodos(w, r, calendarMediaType, "", data.Projection{})
+}
+
+// importCalendarHandler for the "POST /v1/todoInfo.ics" endpoint. It reads the
+// VTODOs of an .ics file, updates the todos whose UID matches and adds the
+// rest, all in one transaction like importTodoInfoHandler
+func (app *application) importCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ctx, cancel := app.extendImportDeadlines(w, r)
+	defer cancel()
+
+	file := app.spoolImport(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+	orgID := app.contextGetMembership(r).OrgID
+	open := func() (importReader, error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return newCalendarImportReader(file, app.models.Todos.ForOrg(orgID))
+	}
+	lists, err := app.models.Lists.ForOrg(orgID).GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runImport(ctx, w, r, open, dryRun, lists, writeCalendarRows)
+}
+
+// createCalendarFeedHandler for the "POST /v1/users/me/calendar-feed"
+// endpoint. It returns the secret URL of the user's feed for the
+// organization. Calling it again replaces the URL
+func (app *application) createCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.contextGetMembership(r)
+	feed, err := app.models.CalendarFeeds.New(membership.UserID, membership.OrgID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	url := "/v1/todoInfo.ics?token=" + feed.Token
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"calendar_feed": feed, "url": url}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCalendarFeedHandler for the "DELETE /v1/users/me/calendar-feed"
+// endpoint. The feed's URL stops working
+func (app *application) deleteCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.contextGetMembership(r)
+	err := app.models.CalendarFeeds.Delete(membership.UserID, membership.OrgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "calendar feed successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
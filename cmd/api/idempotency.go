@@ -0,0 +1,166 @@
+// Filename: cmd/api/idempotency.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The response headers that are stored with a key and sent again on replay.
+// Others, such as the rate limit, belong to the retry
+var idempotentHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// An idempotencyRecorder holds a response back until it has been stored with
+// the key, since what the request wrote is only committed then
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status  int
+	headers map[string][]string
+	body    bytes.Buffer
+}
+
+func (ir *idempotencyRecorder) WriteHeader(status int) {
+	if ir.status == 0 {
+		ir.status = status
+		ir.headers = make(map[string][]string)
+		for _, key := range idempotentHeaders {
+			if values := ir.Header().Values(key); len(values) > 0 {
+				ir.headers[key] = values
+			}
+		}
+	}
+}
+
+func (ir *idempotencyRecorder) Write(b []byte) (int, error) {
+	if ir.status == 0 {
+		ir.WriteHeader(http.StatusOK)
+	}
+	return ir.body.Write(b)
+}
+
+// The send() method sends the response that was held back
+func (ir *idempotencyRecorder) send() {
+	ir.ResponseWriter.WriteHeader(ir.status)
+	ir.ResponseWriter.Write(ir.body.Bytes())
+}
+
+// The idempotent() middleware lets clients retry a POST safely by sending an
+// Idempotency-Key header. The first response for a key is stored for a day
+// and sent again for retries of the same request, while a different request
+// with the key gets a 422. A retry that comes in while the first request is
+// still running waits for it, and gets a 409 if it runs too long. It must
+// be wrapped in requireOrgMember(), which sets the membership.
+//
+// The key holds a database connection until the response is stored. The
+// handler must write through contextGetIdempotencyClaim() rather than take a
+// second connection, which also commits its work together with the response
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		// The handler reads the body again, so keep a copy of it
+		maxBytes := 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The same key may only be used again for the same request. The
+		// organization is part of it since it comes from a header
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.RequestURI())
+		fmt.Fprintf(fingerprint, "org %d\n", app.contextGetMembership(r).OrgID)
+		fingerprint.Write(body)
+
+		claim, stored, err := app.models.IdempotencyKeys.Claim(app.contextGetUser(r).ID, key, fingerprint.Sum(nil))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyReused):
+				v.AddError("Idempotency-Key", "was already used for a different request")
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrIdempotencyKeyInUse):
+				app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed, please try again")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if stored != nil {
+			for key, values := range stored.Headers {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Give the key up if the handler panics, so the request can be retried
+		completed := false
+		defer func() {
+			if !completed {
+				claim.Release()
+			}
+		}()
+		ir := &idempotencyRecorder{ResponseWriter: w}
+		next(ir, app.contextSetIdempotencyClaim(r, claim))
+		if ir.status == 0 {
+			return
+		}
+		// Server errors are not kept, as a retry might succeed. Rolling
+		// back also undoes what the request wrote
+		if ir.status >= http.StatusInternalServerError {
+			ir.send()
+			return
+		}
+		completed = true
+		err = claim.Complete(&data.IdempotentResponse{Status: ir.status, Headers: ir.headers, Body: ir.body.Bytes()})
+		if err != nil {
+			// Nothing was kept, so the client must not be told it was
+			for key := range ir.headers {
+				w.Header().Del(key)
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		ir.send()
+	}
+}
+
+// The deleteExpiredIdempotencyKeys() method removes stored responses once
+// they are too old to be replayed. It runs once per interval until ctx is done
+func (app *application) deleteExpiredIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := app.models.IdempotencyKeys.DeleteExpired()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Filename: cmd/api/import.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+const (
+	// Imports are streamed, so they may be much larger than a JSON body
+	maxImportBytes = 100 << 20
+	// The number of todos sent to the database in one COPY
+	importBatchSize = 1000
+	// The most lines whose errors are reported back
+	maxImportErrors = 100
+	// How long an import may take to be sent, written and answered
+	importTimeout = 10 * time.Minute
+)
+
+// errImportInvalid rolls back an import that has invalid rows
+var errImportInvalid = errors.New("import has invalid rows")
+
+// The fields of a todo an import can set
+var importFields = []string{"list_id", "name", "task", "due"}
+
+// An importRow is one todo read from an import, or the errors that kept it
+// from being read
+type importRow struct {
+	line   int
+	todo   *data.Todo
+	tags   []string
+	errors map[string]string
+}
+
+// An importReader hands out the rows of an import one at a time. Next()
+// returns io.EOF after the last row
+type importReader interface {
+	Next() (importRow, error)
+}
+
+// A txImportReader looks up existing todos while it reads. The rows that are
+// written are read a second time inside the import's transaction, and the
+// reader is given it before the first row, so it sees the todos the import
+// has written so far
+type txImportReader interface {
+	importReader
+	useTx(tx data.TodoModel)
+}
+
+// A csvImportReader reads todos from CSV. The header row names the field of
+// every column, either directly or through the mapping
+type csvImportReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVImportReader(body io.Reader, mapping map[string]string) (*csvImportReader, error) {
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("the CSV header could not be read: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		field, ok := mapping[name]
+		if !ok {
+			field = strings.ToLower(strings.TrimSpace(name))
+		}
+		// Columns that are not todo fields are left out
+		if !validator.In(field, importFields...) {
+			continue
+		}
+		if _, ok := columns[field]; ok {
+			return nil, fmt.Errorf("the CSV header has more than one %s column", field)
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("the CSV header must have a name column")
+	}
+	if _, ok := columns["task"]; !ok {
+		return nil, errors.New("the CSV header must have a task column")
+	}
+	return &csvImportReader{r: r, columns: columns}, nil
+}
+
+func (cr *csvImportReader) Next() (importRow, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		// A broken row does not stop the rest from being read
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return importRow{line: parseError.StartLine, errors: map[string]string{"csv": parseError.Err.Error()}}, nil
+		}
+		return importRow{}, err
+	}
+	line, _ := cr.r.FieldPos(0)
+	row := importRow{line: line, todo: &data.Todo{}, errors: make(map[string]string)}
+	value := func(field string) string {
+		i, ok := cr.columns[field]
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	row.todo.Name = value("name")
+	row.todo.Task = value("task")
+	if listID := value("list_id"); listID != "" {
+		id, err := strconv.ParseInt(listID, 10, 64)
+		if err != nil {
+			row.errors["list_id"] = "must be an integer"
+		}
+		row.todo.ListID = &id
+	}
+	if due := value("due"); due != "" {
+		t, err := parseDue(due)
+		if err != nil {
+			row.errors["due"] = "must be an RFC 3339 time or a YYYY-MM-DD date"
+		}
+		row.todo.Due = &t
+	}
+	return row, nil
+}
+
+// The parseDue() function reads a due time from a spreadsheet cell, which
+// often only holds a date
+func parseDue(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// An ndjsonImportReader reads one todo object per line
+type ndjsonImportReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONImportReader(body io.Reader) *ndjsonImportReader {
+	s := bufio.NewScanner(body)
+	// A single todo is never bigger than a JSON body may be
+	s.Buffer(make([]byte, 64*1024), 1_048_576)
+	return &ndjsonImportReader{s: s}
+}
+
+func (nr *ndjsonImportReader) Next() (importRow, error) {
+	for nr.s.Scan() {
+		nr.line++
+		line := bytes.TrimSpace(nr.s.Bytes())
+		// Blank lines are allowed between todos
+		if len(line) == 0 {
+			continue
+		}
+		var input struct {
+			ListID *int64     `json:"list_id"`
+			Name   string     `json:"name"`
+			Task   string     `json:"task"`
+			Due    *time.Time `json:"due"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&input); err != nil {
+			return importRow{line: nr.line, errors: map[string]string{"json": err.Error()}}, nil
+		}
+		return importRow{line: nr.line, todo: &data.Todo{ListID: input.ListID, Name: input.Name, Task: input.Task, Due: input.Due}}, nil
+	}
+	if err := nr.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+// importTodoInfoHandler for the "POST /v1/todoInfo/import" endpoint. It reads
+// todos from a CSV or NDJSON body and adds them all in one transaction, or none
+// of them if any row is invalid. With dry_run=true the rows are only checked
+func (app *application) importTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	dryRun := app.readBool(qs, "dry_run", false, v)
+	// Spreadsheet columns can be mapped to todo fields, e.g. map=Title:name
+	mapping := make(map[string]string)
+	for _, pair := range app.readCSV(qs, "map", nil) {
+		column, field, ok := strings.Cut(pair, ":")
+		v.Check(ok && validator.In(field, importFields...), "map", "must be a list of column:field pairs")
+		mapping[column] = field
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != csvMediaType && mediaType != ndjsonMediaType {
+		app.unsupportedMediaTypeResponse(w, r, mediaType)
+		return
+	}
+	ctx, cancel := app.extendImportDeadlines(w, r)
+	defer cancel()
+
+	file := app.spoolImport(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+	open := func() (importReader, error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if mediaType == csvMediaType {
+			return newCSVImportReader(file, mapping)
+		}
+		return newNDJSONImportReader(file), nil
+	}
+
+	lists, err := app.models.Lists.ForOrg(app.contextGetMembership(r).OrgID).GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.runImport(ctx, w, r, open, dryRun, lists, copyImportRows)
+}
+
+// The copyImportRows() function writes a batch of imported todos with COPY
+func copyImportRows(tx data.TodoModel, rows []importRow) error {
+	todos := make([]*data.Todo, len(rows))
+	for i := range rows {
+		todos[i] = rows[i].todo
+	}
+	return tx.CopyIn(todos)
+}
+
+// The extendImportDeadlines() method gives an import importTimeout instead of
+// the server's read and write timeouts, which are meant for small requests. It
+// must be called before the body is read. The returned context is for the
+// import's transaction, which also ends if the client goes away
+func (app *application) extendImportDeadlines(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(importTimeout)
+	rc := http.NewResponseController(w)
+	// Small imports still work where the deadlines cannot be moved
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		app.logError(r, err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		app.logError(r, err)
+	}
+	return context.WithDeadline(r.Context(), deadline)
+}
+
+// The spoolImport() method copies the body of an import to a temporary file
+// before anything is checked or written. Waiting on a slow client then holds
+// no database connection, and the rows can be read twice. It sends the error
+// response and returns nil if the body could not be read. Closing the file
+// removes it
+func (app *application) spoolImport(w http.ResponseWriter, r *http.Request) *os.File {
+	file, err := os.CreateTemp("", "todo-import-*")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	// The open file can still be used once its name is gone
+	os.Remove(file.Name())
+
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the import must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("the import could not be read: %w", err))
+		}
+		return nil
+	}
+	return file
+}
+
+// The scanImport() function reads every row of an import and checks it. The
+// valid rows are handed to fn, and the errors of the invalid ones are returned
+// by line
+func scanImport(reader importReader, listIDs map[int64]bool, fn func(importRow) error) (int, map[string]map[string]string, error) {
+	invalid := 0
+	lineErrors := make(map[string]map[string]string)
+	for {
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return invalid, lineErrors, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		v := validator.New()
+		for key, message := range row.errors {
+			v.AddError(key, message)
+		}
+		if row.todo != nil {
+			data.ValidateTodo(v, row.todo)
+			if row.todo.ListID != nil && !listIDs[*row.todo.ListID] {
+				v.AddError("list_id", "must be a list of this organization")
+			}
+		}
+		if !v.Valid() {
+			invalid++
+			if len(lineErrors) < maxImportErrors {
+				lineErrors[strconv.Itoa(row.line)] = v.Errors
+			}
+			continue
+		}
+		if err := fn(row); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// The runImport() method checks every row of an import that open() reads.
+// Only if all of them are valid, and it is not a dry run, are the rows read
+// again and written by write() in batches, all in one transaction that runs
+// in ctx. Otherwise the errors are sent back by line. Errors from open() are
+// the client's
+func (app *application) runImport(ctx context.Context, w http.ResponseWriter, r *http.Request, open func() (importReader, error), dryRun bool, lists []*data.List, write func(data.TodoModel, []importRow) error) {
+	// Check list ids up front so a bad one is reported with its line
+	listIDs := make(map[int64]bool, len(lists))
+	for _, list := range lists {
+		listIDs[list.ID] = true
+	}
+
+	// The first pass holds no transaction, so checking a large import does
+	// not keep a connection from the other requests
+	reader, err := open()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	valid, imported := 0, 0
+	invalid, lineErrors, err := scanImport(reader, listIDs, func(importRow) error {
+		valid++
+		return nil
+	})
+	if err == nil && !dryRun && invalid == 0 && valid > 0 {
+		userID := app.contextGetUser(r).ID
+		err = app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).InTxContext(ctx, func(tx data.TodoModel) error {
+			reader, err := open()
+			if err != nil {
+				return err
+			}
+			if reader, ok := reader.(txImportReader); ok {
+				reader.useTx(tx)
+			}
+			valid = 0
+			batch := make([]importRow, 0, importBatchSize)
+			flush := func() error {
+				if len(batch) == 0 {
+					return nil
+				}
+				err := write(tx, batch)
+				imported += len(batch)
+				batch = batch[:0]
+				return err
+			}
+			invalid, lineErrors, err = scanImport(reader, listIDs, func(row importRow) error {
+				valid++
+				row.todo.CreatedBy = &userID
+				batch = append(batch, row)
+				if len(batch) == importBatchSize {
+					return flush()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// A row can turn invalid between the passes, e.g. when its todo
+			// was changed. Roll back the batches that were already written
+			if invalid > 0 {
+				return errImportInvalid
+			}
+			return flush()
+		})
+	}
+	switch {
+	case err == nil, errors.Is(err, errImportInvalid):
+	case errors.Is(err, bufio.ErrTooLong):
+		app.badRequestResponse(w, r, errors.New("the import has a line longer than 1MB"))
+		return
+	case errors.Is(err, data.ErrInvalidList):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "a list was deleted during the import")
+		return
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+		return
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"dry_run": dryRun, "valid": valid, "invalid": invalid}
+	if len(lineErrors) > 0 {
+		env["errors"] = lineErrors
+	}
+	status := http.StatusOK
+	switch {
+	case dryRun:
+	case invalid > 0:
+		status = http.StatusUnprocessableEntity
+		env["imported"] = 0
+	default:
+		status = http.StatusCreated
+		env["imported"] = imported
+	}
+	err = app.writeJSON(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
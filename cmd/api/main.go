@@ -5,15 +5,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/jsonlog"
+	"todo.jamesfaber.net/internal/jwt"
+	"todo.jamesfaber.net/internal/mailer"
+	"todo.jamesfaber.net/internal/sms"
 )
 
 // The application version nimber
@@ -30,13 +35,74 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	jwt struct {
+		alg        string
+		keyFile    string
+		issuer     string
+		audience   string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	sms struct {
+		logFile      string
+		reminderLead time.Duration
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+		// The limit per IP address that applies before authentication
+		ipRPS   float64
+		ipBurst int
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	// CalDAV clients with a sync token older than the retention sync afresh
+	caldav struct {
+		tombstoneRetention time.Duration
+	}
+	compress struct {
+		enabled  bool
+		minBytes int
+	}
+	// JSON responses are indented unless compact is set
+	json struct {
+		compact bool
+	}
+	shutdownTimeout time.Duration
+	logLevel        jsonlog.Level
+	// The admin listener that serves /metrics, off when empty
+	adminAddr string
+	// Reject requests that do not fit the OpenAPI document
+	validateRequests bool
 }
 
 // Dependency injection - the process of supplying a resource that a given piece of code requires.
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
+	config   config
+	logger   *jsonlog.Logger
+	metrics  *appMetrics
+	openapi  *apiDocument
+	models   data.Models
+	jwtKeys  *jwt.Keys
+	sessions *sessionTracker
+	mailer   mailer.Mailer
+	sms      sms.SMSSender
+	// Tracks the goroutines started with background()
+	wg sync.WaitGroup
+	// Cancelled as soon as shutdown begins, which stops the periodic jobs
+	shutdown context.Context
+	stopJobs context.CancelFunc
+	// Tracks the periodic jobs started with startJob()
+	jobs sync.WaitGroup
 }
 
 func main() {
@@ -49,45 +115,122 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	// Settings for the JWT access tokens and the refresh tokens that go with them
+	flag.StringVar(&cfg.jwt.alg, "jwt-alg", jwt.HS256, "JWT signing algorithm (HS256 | EdDSA)")
+	flag.StringVar(&cfg.jwt.keyFile, "jwt-key-file", os.Getenv("TODO_JWT_KEY_FILE"), "File holding the HS256 secret or the EdDSA PEM private key")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "todo.jamesfaber.net", "JWT issuer")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "todo.jamesfaber.net/api", "JWT audience")
+	flag.DurationVar(&cfg.jwt.accessTTL, "jwt-access-ttl", 15*time.Minute, "Access token lifetime")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	// Settings for the SMTP server that sends our emails
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("TODO_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("TODO_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Todo <no-reply@todo.jamesfaber.net>", "SMTP sender")
+	// Text messages are written to a log file (or stdout) instead of being sent
+	flag.StringVar(&cfg.sms.logFile, "sms-log-file", "", "File that SMS messages are written to (default stdout)")
+	flag.DurationVar(&cfg.sms.reminderLead, "sms-reminder-lead", time.Hour, "How long before a todo is due to send its SMS reminder")
+	// Settings for the per client rate limiter
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.ipRPS, "limiter-ip-rps", 10, "Rate limiter maximum requests per second per IP address, checked before authentication")
+	flag.IntVar(&cfg.limiter.ipBurst, "limiter-ip-burst", 20, "Rate limiter maximum burst per IP address, checked before authentication")
+	// The origins that browsers may call the API from
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+	flag.DurationVar(&cfg.caldav.tombstoneRetention, "caldav-tombstone-retention", 30*24*time.Hour, "How long CalDAV clients are told about removed todos before they have to sync afresh")
+	flag.BoolVar(&cfg.compress.enabled, "compress-enabled", true, "Compress responses with gzip or deflate when the client accepts it")
+	flag.IntVar(&cfg.compress.minBytes, "compress-min-bytes", 1024, "Smallest response body that is compressed")
+	flag.BoolVar(&cfg.json.compact, "json-compact", os.Getenv("TODO_JSON_COMPACT") == "true", "Send JSON without indentation (?compact=true or false overrides it per request)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 20*time.Second, "How long to wait for in-flight requests and background work on shutdown")
+	flag.StringVar(&cfg.adminAddr, "admin-addr", "localhost:4001", "Address of the admin listener that serves /metrics (empty to turn it off)")
+	flag.BoolVar(&cfg.validateRequests, "validate-requests", false, "Reject requests whose query or JSON body does not fit /v1/openapi.json")
+	// Log entries below this level are dropped
+	cfg.logLevel = jsonlog.LevelInfo
+	flag.Func("log-level", "Minimum log level (debug | info | error | fatal | off) (default info)", func(val string) error {
+		level, err := jsonlog.ParseLevel(val)
+		cfg.logLevel = level
+		return err
+	})
 	// To parse -is where a string of commands – usually a program – is separated into more easily processed components, which are analyzed for correct syntax and then attached to tags that define each component.
 	flag.Parse()
+	if cfg.limiter.enabled && (cfg.limiter.rps <= 0 || cfg.limiter.burst < 1) {
+		fmt.Fprintln(os.Stderr, "-limiter-rps must be greater than zero and -limiter-burst at least 1")
+		os.Exit(2)
+	}
+	if cfg.limiter.enabled && (cfg.limiter.ipRPS <= 0 || cfg.limiter.ipBurst < 1) {
+		fmt.Fprintln(os.Stderr, "-limiter-ip-rps must be greater than zero and -limiter-ip-burst at least 1")
+		os.Exit(2)
+	}
 
 	//Create a logger - Logging is a means of tracking events that happen when some software runs.
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	// Entries are written to stdout as JSON, one per line
+	logger := jsonlog.New(os.Stdout, cfg.logLevel)
 	// Create the connection pool
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err, nil)
+		logger.PrintFatal(err, nil)
 	}
 
 	defer db.Close()
 	// Log the successful connection pool
-	logger.Println("database connection pool established", nil)
+	logger.PrintInfo("database connection pool established", nil)
 
-	//Create an instance of our applications struct
-	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+	// Set up where text messages go
+	smsOut := os.Stdout
+	if cfg.sms.logFile != "" {
+		smsOut, err = os.OpenFile(cfg.sms.logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer smsOut.Close()
 	}
 
-	//create our new servemux
-	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/healthcheck", app.healthcheckHandler)
+	// Load the keys used to sign access tokens
+	jwtKeys, err := loadJWTKeys(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Time the todo queries for the metrics
+	appMetrics := newAppMetrics(db)
+	models := data.NewModels(db)
+	models.Todos.Observe = appMetrics.observeTodoQuery
 
-	// create our http server
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	// The periodic jobs run until shutdown begins
+	shutdown, stopJobs := context.WithCancel(context.Background())
+
+	//Create an instance of our applications struct
+	app := &application{
+		config:   cfg,
+		logger:   logger,
+		metrics:  appMetrics,
+		models:   models,
+		jwtKeys:  jwtKeys,
+		sessions: newSessionTracker(),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		sms:      sms.NewLogSender(smsOut),
+		shutdown: shutdown,
+		stopJobs: stopJobs,
 	}
+	// Write session usage out in batches instead of on every request
+	app.startJob(app.flushSessionUsage, time.Minute)
+	// Check for todos that need an SMS reminder
+	app.startJob(app.sendReminders, time.Minute)
+	// Forget idempotency keys that can no longer be replayed
+	app.startJob(app.deleteExpiredIdempotencyKeys, time.Hour)
+	// Forget removed todos that CalDAV clients have had long enough to see
+	app.startJob(app.pruneTombstones, time.Hour)
 
-	// Start our server
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+	// Start our server and block until it has shut down
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 }
 
 // The openDB() function returns a *sql.DB connection pool
@@ -115,3 +258,15 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 
 }
+
+// The loadJWTKeys() function reads the signing key from the configured file.
+// Without a key file a random secret is generated outside of production
+func loadJWTKeys(cfg config) (*jwt.Keys, error) {
+	if cfg.jwt.keyFile != "" {
+		return jwt.LoadKeys(cfg.jwt.alg, cfg.jwt.keyFile)
+	}
+	if cfg.env == "production" {
+		return nil, errors.New("-jwt-key-file must be set in production")
+	}
+	return jwt.RandomKeys()
+}
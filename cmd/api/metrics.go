@@ -0,0 +1,135 @@
+// Filename: cmd/api/metrics.go
+
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todo.jamesfaber.net/internal/metrics"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The metrics we keep about requests and the database
+type appMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.Gauge
+	queries  *metrics.HistogramVec
+}
+
+// The newAppMetrics() function registers our metrics. The connection pool
+// gauges are read from db when the metrics are scraped
+func newAppMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("http_requests_total", "Requests answered, by method, route pattern and status.", "method", "route", "status"),
+		duration: registry.NewHistogramVec("http_request_duration_seconds", "Time taken to answer requests, by method, route pattern and status.", metrics.DefaultBuckets, "method", "route", "status"),
+		inFlight: registry.NewGauge("http_requests_in_flight", "Requests being answered right now."),
+		queries:  registry.NewHistogramVec("todo_query_duration_seconds", "Time taken by the queries of the todo model.", metrics.DefaultBuckets, "query"),
+	}
+
+	stats := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(db.Stats())
+		}
+	}
+	registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("db_open_connections", "Established connections, in use and idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("db_in_use_connections", "Connections in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("db_idle_connections", "Idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("db_wait_count_total", "Times a query waited for a connection.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed because they were idle too long.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	registry.NewCounterFunc("db_max_lifetime_closed_total", "Connections closed because of their maximum lifetime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+	return m
+}
+
+// The observeTodoQuery() method is the Observe hook of the todo model
+func (m *appMetrics) observeTodoQuery(query string, duration time.Duration) {
+	m.queries.Observe(duration.Seconds(), query)
+}
+
+// The recordRoute() method returns a handler that notes the route pattern it
+// was registered for, so requests are counted by pattern rather than by URL
+func (app *application) recordRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info := app.contextGetRequestInfo(r); info != nil {
+			info.Route = pattern
+		}
+		next(w, r)
+	}
+}
+
+// The methods that get a label of their own. Any method can be sent, so the
+// others share one
+var metricMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions, methodPropfind, methodReport,
+}
+
+// The metricMethod() function returns the method label of a request
+func metricMethod(method string) string {
+	if validator.In(method, metricMethods...) {
+		return method
+	}
+	return "other"
+}
+
+// The recordMetrics() middleware counts and times every request
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.metrics.inFlight.Inc()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		// Deferred so that aborted responses are counted too
+		defer func() {
+			app.metrics.inFlight.Dec()
+			// Requests that matched no route share one label so that
+			// scanners cannot blow up the number of series
+			route := "unmatched"
+			if info := app.contextGetRequestInfo(r); info != nil && info.Route != "" {
+				route = info.Route
+			}
+			method := metricMethod(r.Method)
+			status := strconv.Itoa(sr.result(!completed))
+			app.metrics.requests.Inc(method, route, status)
+			app.metrics.duration.Observe(time.Since(start).Seconds(), method, route, status)
+		}()
+		next.ServeHTTP(sr, r)
+		completed = true
+	})
+}
+
+// The adminRoutes() method returns the handler of the admin listener, which
+// is kept off the public port
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", app.metricsHandler)
+	return mux
+}
+
+// metricsHandler for the "GET /metrics" endpoint of the admin listener. It
+// writes our metrics in the Prometheus text exposition format
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	err := app.metrics.registry.Write(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,461 @@
+// Filename: cmd/api/middleware.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/jwt"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// Request ids sent by clients or proxies must be short and printable
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// The requestID() middleware gives every request an id, the one in the
+// X-Request-ID header if there is a usable one. The id is sent back and goes
+// into every log entry of the request
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validator.Matches(id, requestIDRX) {
+			b := make([]byte, 16)
+			// crypto/rand only fails if the system has no randomness to give
+			if _, err := rand.Read(b); err != nil {
+				panic(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestInfo(r, &requestInfo{ID: id})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A statusRecorder notes the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if !sr.wroteHeader {
+		sr.status = status
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.wroteHeader = true
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// Flush() lets streamed responses through
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// The result() method returns the status of the response. A handler that
+// panicked before it wrote anything is answered with a 500 by recoverPanic()
+func (sr *statusRecorder) result(panicked bool) int {
+	if panicked && !sr.wroteHeader {
+		return http.StatusInternalServerError
+	}
+	return sr.status
+}
+
+// The logAccess() middleware logs every request once it has been answered,
+// with its status, size and how long it took
+func (app *application) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		// Deferred so that aborted responses are logged too
+		defer func() {
+			properties := map[string]string{
+				"method":      r.Method,
+				"uri":         redactedURI(r.URL),
+				"remote_addr": r.RemoteAddr,
+				"status":      strconv.Itoa(sr.result(!completed)),
+				"bytes":       strconv.Itoa(sr.bytes),
+				"duration_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+			}
+			if info := app.contextGetRequestInfo(r); info != nil {
+				properties["request_id"] = info.ID
+				if info.UserID != 0 {
+					properties["user_id"] = strconv.FormatInt(info.UserID, 10)
+				}
+			}
+			app.logger.PrintInfo("request", properties)
+		}()
+		next.ServeHTTP(sr, r)
+		completed = true
+	})
+}
+
+// The recoverPanic() middleware turns a panic in a handler or in one of the
+// other middlewares into a JSON 500 response instead of a dropped connection.
+// Only requestID() runs outside of it, so the panic is logged with the id
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// net/http uses this panic to abort a response on purpose
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				// Close the connection after the response has been sent
+				w.Header().Set("Connection", "close")
+				// Log the panic along with the stack trace
+				app.serverErrorResponse(w, r, fmt.Errorf("panic: %v\n%s", err, debug.Stack()))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The enableCORS() middleware lets browsers on a trusted origin, such as the
+// Elm frontend, call the API. Preflight requests are answered right here
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response differs by origin and preflight method, so caches must
+		// keep them apart even for origins we do not trust
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && validator.In(origin, app.config.cors.trustedOrigins...) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// Let the frontend read the headers it needs
+			w.Header().Set("Access-Control-Expose-Headers", "Deprecation, ETag, Idempotent-Replayed, Link, Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, Sunset, X-Request-ID")
+
+			// Check for a preflight request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, X-Org-ID, X-Request-ID")
+				// Let the browser cache the preflight response for an hour
+				w.Header().Set("Access-Control-Max-Age", "3600")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The authenticate() middleware reads the bearer token from the Authorization
+// header and adds the user it was issued to to the request context. Access
+// tokens are self contained so no database lookup is needed here
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Authorization header
+		w.Header().Add("Vary", "Authorization")
+		authorizationHeader := r.Header.Get("Authorization")
+		// No header means an anonymous user
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Split the header into "Bearer" and the token
+		headerParts := strings.Split(authorizationHeader, " ")
+		// CalDAV clients log in with Basic auth, which the CalDAV handlers check
+		if len(headerParts) == 2 && headerParts[0] == "Basic" && (strings.HasPrefix(r.URL.Path, davRoot) || r.URL.Path == davWellKnown) {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		claims, err := app.jwtKeys.Verify(headerParts[1], time.Now(), app.config.jwt.issuer, app.config.jwt.audience)
+		if err != nil {
+			switch {
+			case errors.Is(err, jwt.ErrInvalidToken), errors.Is(err, jwt.ErrExpiredToken):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+		if err != nil || userID < 1 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		// Tokens of sessions revoked on this instance stop working right away.
+		// Elsewhere they run out within the access token lifetime
+		if claims.Session != "" {
+			if app.sessions.isRevoked(claims.Session) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			app.sessions.touch(claims.Session)
+			r = app.contextSetSession(r, claims.Session)
+		}
+		r = app.contextSetUser(r, &data.User{ID: userID})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireDAVUser() middleware logs CalDAV clients in. They send the
+// user's email address and a CalDAV app password with Basic auth, and work on
+// the password's organization. Calendar feed tokens only read the feed, so
+// they are not accepted here
+func (app *application) requireDAVUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("DAV", davCompliance)
+		email, plaintext, ok := r.BasicAuth()
+		v := validator.New()
+		if data.ValidateDAVPassword(v, plaintext); !ok || !v.Valid() {
+			app.basicAuthenticationRequiredResponse(w, r)
+			return
+		}
+		password, err := app.models.DAVPasswords.GetForPassword(plaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.basicAuthenticationRequiredResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		user, err := app.models.Users.Get(password.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !strings.EqualFold(user.Email, email) {
+			app.basicAuthenticationRequiredResponse(w, r)
+			return
+		}
+		membership, err := app.models.Organizations.GetMembership(password.OrgID, password.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.basicAuthenticationRequiredResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		r = app.contextSetUser(r, user)
+		r = app.contextSetMembership(r, membership)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAuthenticatedUser() middleware rejects anonymous users
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireOrgMember() middleware works out which organization the request
+// is for and checks that the user belongs to it. The organization is taken
+// from the X-Org-ID header and defaults to the user's first organization
+func (app *application) requireOrgMember(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the organization
+		w.Header().Add("Vary", "X-Org-ID")
+		user := app.contextGetUser(r)
+
+		var membership *data.Membership
+		var err error
+		if orgHeader := r.Header.Get("X-Org-ID"); orgHeader != "" {
+			orgID, parseErr := strconv.ParseInt(orgHeader, 10, 64)
+			if parseErr != nil || orgID < 1 {
+				app.badRequestResponse(w, r, errors.New("invalid X-Org-ID header"))
+				return
+			}
+			membership, err = app.models.Organizations.GetMembership(orgID, user.ID)
+		} else {
+			membership, err = app.models.Organizations.GetDefaultMembership(user.ID)
+		}
+		if err != nil {
+			switch {
+			// Do not tell outsiders whether the organization exists
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notPermittedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		r = app.contextSetMembership(r, membership)
+		next.ServeHTTP(w, r)
+	}
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The rateLimitIP() middleware gives every IP address a token bucket. It runs
+// before authenticate(), so requests with bad credentials use up the bucket
+// too. The bucket is larger than the one of rateLimit() since many users can
+// share an address
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+	return app.limitBy(app.config.limiter.ipRPS, app.config.limiter.ipBurst, func(r *http.Request) string {
+		return "ip:" + app.clientIP(r)
+	}, next)
+}
+
+// The rateLimit() middleware gives every client a token bucket. Clients are
+// told apart by user once they are authenticated and by IP address before
+// that
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+	return app.limitBy(app.config.limiter.rps, app.config.limiter.burst, func(r *http.Request) string {
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			return "user:" + strconv.FormatInt(user.ID, 10)
+		}
+		return "ip:" + app.clientIP(r)
+	}, next)
+}
+
+// The limitBy() method returns a handler that keeps a token bucket for every
+// key and answers 429 once the bucket of the request's key is empty. The
+// RateLimit-* headers tell the client how much of the bucket is left
+func (app *application) limitBy(rps float64, burst int, keyOf func(r *http.Request) string, next http.Handler) http.Handler {
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+	// Forget clients that have not been seen for a while so the map stays small
+	app.startJob(func(ctx context.Context, interval time.Duration) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				for key, client := range clients {
+					if time.Since(client.lastSeen) > 3*time.Minute {
+						delete(clients, key)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}, time.Minute)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := keyOf(r)
+
+		mu.Lock()
+		c, found := clients[key]
+		if !found {
+			c = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+			clients[key] = c
+		}
+		now := time.Now()
+		c.lastSeen = now
+		// Take a token if one is available right now
+		reservation := c.limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			reservation.CancelAt(now)
+		}
+		remaining := math.Max(0, c.limiter.TokensAt(now))
+		mu.Unlock()
+
+		// Seconds until the bucket is full again
+		reset := math.Ceil((float64(burst) - remaining) / rps)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+		if delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The validateRequests() middleware rejects requests whose query parameters
+// or JSON body do not fit the OpenAPI document. It is turned on with the
+// -validate-requests flag. Path parameters are left to the handlers, which
+// answer 404 for ids that cannot exist
+func (app *application) validateRequests(next http.Handler) http.Handler {
+	if !app.config.validateRequests {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := app.openapi.match(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		errs := make(map[string]string)
+		op.checkQuery(r.URL.Query(), errs)
+		if schema := op.bodySchema(r.Header.Get("Content-Type")); schema != nil {
+			// The handler reads the body again, so keep a copy of it
+			maxBytes := 1_048_576
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+			if err != nil {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			// Malformed JSON is reported by readJSON() in the handler
+			var value interface{}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if dec.Decode(&value) == nil {
+				app.openapi.check(schema, value, "", errs)
+			}
+		}
+		if len(errs) > 0 {
+			app.failedValidationResponse(w, r, errs)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,1219 @@
+// Filename: cmd/api/openapi.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The OpenAPI document is built from the routes that routes() registers and
+// the operations described in apiOperations(). A route without an operation
+// stops the server from starting, so the document cannot fall behind
+
+const openAPIVersion = "3.1.0"
+
+// An apiSchema is the subset of JSON Schema that our document uses. Type is a
+// string, or a list of strings for nullable values
+type apiSchema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 interface{}           `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Enum                 []string              `json:"enum,omitempty"`
+	Properties           map[string]*apiSchema `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties interface{}           `json:"additionalProperties,omitempty"`
+	Items                *apiSchema            `json:"items,omitempty"`
+}
+
+type apiParameter struct {
+	Name        string     `json:"name"`
+	In          string     `json:"in"`
+	Description string     `json:"description,omitempty"`
+	Required    bool       `json:"required,omitempty"`
+	Schema      *apiSchema `json:"schema"`
+}
+
+type apiMediaType struct {
+	Schema *apiSchema `json:"schema,omitempty"`
+}
+
+type apiRequestBody struct {
+	Required bool                    `json:"required,omitempty"`
+	Content  map[string]apiMediaType `json:"content"`
+}
+
+type apiResponse struct {
+	Ref         string                  `json:"$ref,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Content     map[string]apiMediaType `json:"content,omitempty"`
+}
+
+// The kinds of credentials a route takes. They decide the security
+// requirement, the X-Org-ID header and the error responses of an operation
+type apiAuth int
+
+const (
+	authNone apiAuth = iota
+	authUser
+	authOrg
+	authDAV
+)
+
+type apiOperation struct {
+	OperationID string                  `json:"operationId"`
+	Summary     string                  `json:"summary"`
+	Tags        []string                `json:"tags,omitempty"`
+	Deprecated  bool                    `json:"deprecated,omitempty"`
+	Security    []map[string][]string   `json:"security,omitempty"`
+	Parameters  []*apiParameter         `json:"parameters,omitempty"`
+	RequestBody *apiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*apiResponse `json:"responses"`
+	auth        apiAuth
+}
+
+// An apiPathItem holds the operations of one path. OpenAPI has no room for
+// the WebDAV methods, so they are listed under an extension
+type apiPathItem struct {
+	Get     *apiOperation            `json:"get,omitempty"`
+	Put     *apiOperation            `json:"put,omitempty"`
+	Post    *apiOperation            `json:"post,omitempty"`
+	Delete  *apiOperation            `json:"delete,omitempty"`
+	Options *apiOperation            `json:"options,omitempty"`
+	Patch   *apiOperation            `json:"patch,omitempty"`
+	WebDAV  map[string]*apiOperation `json:"x-webdav-methods,omitempty"`
+}
+
+// The operation() method returns the slot for a method's operation, or nil
+// for a method the path item has no slot for
+func (p *apiPathItem) operation(method string) **apiOperation {
+	switch method {
+	case http.MethodGet:
+		return &p.Get
+	case http.MethodPut:
+		return &p.Put
+	case http.MethodPost:
+		return &p.Post
+	case http.MethodDelete:
+		return &p.Delete
+	case http.MethodOptions:
+		return &p.Options
+	case http.MethodPatch:
+		return &p.Patch
+	}
+	return nil
+}
+
+type apiSecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type apiDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths      map[string]*apiPathItem `json:"paths"`
+	Components struct {
+		Schemas         map[string]*apiSchema         `json:"schemas"`
+		Responses       map[string]*apiResponse       `json:"responses"`
+		SecuritySchemes map[string]*apiSecurityScheme `json:"securitySchemes"`
+	} `json:"components"`
+}
+
+// An apiRoute is a method and httprouter pattern that routes() registered
+type apiRoute struct {
+	method, pattern string
+}
+
+// The apiPath() function turns an httprouter pattern into an OpenAPI path
+func apiPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// The newAPIDocument() function describes the routes. It panics if one of
+// them has no operation in apiOperations()
+func newAPIDocument(routes []apiRoute) *apiDocument {
+	doc := &apiDocument{OpenAPI: openAPIVersion, Paths: make(map[string]*apiPathItem)}
+	doc.Info.Title = "Todo API"
+	doc.Info.Version = version
+	doc.Info.Description = "The todoInfo, lists, calendar-feed and caldav-password endpoints work on the organization named in the X-Org-ID header " +
+		"(default: the first organization the user joined). Errors are sent as {\"error\": message}, and failed " +
+		"validation as {\"error\": {field: message}}."
+	doc.Components.Schemas = apiSchemas()
+	doc.Components.Responses = apiResponses()
+	doc.Components.SecuritySchemes = map[string]*apiSecurityScheme{
+		"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "An access token from POST /v1/tokens/authentication"},
+		"davAuth":    {Type: "http", Scheme: "basic", Description: "The email address and a CalDAV app password from POST /v1/users/me/caldav-password"},
+	}
+
+	operations := apiOperations()
+	for _, route := range routes {
+		key := route.method + " " + route.pattern
+		op, ok := operations[key]
+		if !ok {
+			panic("openapi: no operation for " + key)
+		}
+		op.finish()
+
+		path := apiPath(route.pattern)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &apiPathItem{}
+			doc.Paths[path] = item
+		}
+		if slot := item.operation(route.method); slot != nil {
+			*slot = op
+			continue
+		}
+		if item.WebDAV == nil {
+			item.WebDAV = make(map[string]*apiOperation)
+		}
+		item.WebDAV[route.method] = op
+	}
+	return doc
+}
+
+// The finish() method adds what follows from the credentials of an operation
+// and the responses that every operation can give
+func (op *apiOperation) finish() {
+	switch op.auth {
+	case authUser:
+		op.Security = []map[string][]string{{"bearerAuth": {}}}
+		op.Responses["401"] = errorRef("Unauthorized")
+	case authOrg:
+		op.Security = []map[string][]string{{"bearerAuth": {}}}
+		op.Parameters = append(op.Parameters, &apiParameter{
+			Name: "X-Org-ID", In: "header", Description: "The organization to work on",
+			Schema: &apiSchema{Type: "integer", Format: "int64"},
+		})
+		op.Responses["401"] = errorRef("Unauthorized")
+		op.Responses["403"] = errorRef("Forbidden")
+	case authDAV:
+		op.Security = []map[string][]string{{"davAuth": {}}}
+		op.Responses["401"] = &apiResponse{Description: "Basic authentication is needed"}
+	}
+	// JSON can be asked for without indentation anywhere but in CalDAV
+	if op.auth != authDAV {
+		op.Parameters = append(op.Parameters, query("compact", "Send JSON without indentation, or with it when false", typed("boolean")))
+	}
+	op.Responses["429"] = errorRef("TooManyRequests")
+	op.Responses["500"] = errorRef("ServerError")
+}
+
+// Helpers that keep the operations and schemas below short
+
+func schemaRef(name string) *apiSchema {
+	return &apiSchema{Ref: "#/components/schemas/" + name}
+}
+
+func errorRef(name string) *apiResponse {
+	return &apiResponse{Ref: "#/components/responses/" + name}
+}
+
+func typed(t string) *apiSchema {
+	return &apiSchema{Type: t}
+}
+
+func int64Schema() *apiSchema {
+	return &apiSchema{Type: "integer", Format: "int64"}
+}
+
+func dateTime() *apiSchema {
+	return &apiSchema{Type: "string", Format: "date-time"}
+}
+
+// The nullable() function lets a schema of a simple type be null too
+func nullable(s *apiSchema) *apiSchema {
+	s.Type = []string{s.Type.(string), "null"}
+	return s
+}
+
+func enum(values ...string) *apiSchema {
+	return &apiSchema{Type: "string", Enum: values}
+}
+
+func arrayOf(items *apiSchema) *apiSchema {
+	return &apiSchema{Type: "array", Items: items}
+}
+
+// The object() function returns the schema of an object that takes no other
+// properties, as readJSON() rejects unknown keys
+func object(properties map[string]*apiSchema, required ...string) *apiSchema {
+	sort.Strings(required)
+	return &apiSchema{Type: "object", Properties: properties, Required: required, AdditionalProperties: false}
+}
+
+// The envelopeOf() function returns the schema of an envelope in which every
+// key is sent
+func envelopeOf(properties map[string]*apiSchema) *apiSchema {
+	required := make([]string, 0, len(properties))
+	for key := range properties {
+		required = append(required, key)
+	}
+	sort.Strings(required)
+	return &apiSchema{Type: "object", Properties: properties, Required: required}
+}
+
+func jsonBody(s *apiSchema) *apiRequestBody {
+	return &apiRequestBody{Required: true, Content: map[string]apiMediaType{"application/json": {Schema: s}}}
+}
+
+// The fileBody() function describes a request body that is not JSON. The
+// handlers check those themselves
+func fileBody(mediaTypes ...string) *apiRequestBody {
+	body := &apiRequestBody{Required: true, Content: make(map[string]apiMediaType)}
+	for _, mediaType := range mediaTypes {
+		body.Content[mediaType] = apiMediaType{Schema: typed("string")}
+	}
+	return body
+}
+
+func jsonResponse(description string, s *apiSchema) *apiResponse {
+	return &apiResponse{Description: description, Content: map[string]apiMediaType{"application/json": {Schema: s}}}
+}
+
+func fileResponse(description string, mediaTypes ...string) *apiResponse {
+	response := &apiResponse{Description: description, Content: make(map[string]apiMediaType)}
+	for _, mediaType := range mediaTypes {
+		response.Content[mediaType] = apiMediaType{Schema: typed("string")}
+	}
+	return response
+}
+
+func messageResponse(description string) *apiResponse {
+	return jsonResponse(description, schemaRef("Message"))
+}
+
+func query(name string, description string, s *apiSchema) *apiParameter {
+	return &apiParameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+func pathParam(name string, s *apiSchema) *apiParameter {
+	return &apiParameter{Name: name, In: "path", Required: true, Schema: s}
+}
+
+// The apiSchemas() function returns the shared schemas of the document
+func apiSchemas() map[string]*apiSchema {
+	return map[string]*apiSchema{
+		"Error": envelopeOf(map[string]*apiSchema{"error": typed("string")}),
+		"ValidationError": envelopeOf(map[string]*apiSchema{"error": {
+			Type: "object", Description: "A message for each field that failed validation",
+			AdditionalProperties: typed("string"),
+		}}),
+		"Message": envelopeOf(map[string]*apiSchema{"message": typed("string")}),
+		"Metadata": {Type: "object", Properties: map[string]*apiSchema{
+			"current_page":  typed("integer"),
+			"page_size":     typed("integer"),
+			"first_page":    typed("integer"),
+			"last_page":     typed("integer"),
+			"total_records": {Type: "integer", Description: "Left out when total=none, and an estimate when total=estimate"},
+			"total":         enum("exact", "estimate", "none"),
+			"has_next":      typed("boolean"),
+			"next_cursor":   {Type: "string", Description: "Pass as cursor to get the next page"},
+		}, Required: []string{"has_next", "total"}},
+		"Todo": {Type: "object", Properties: map[string]*apiSchema{
+			"id":             int64Schema(),
+			"list_id":        int64Schema(),
+			"parent_id":      {Type: "integer", Format: "int64", Description: "The todo this is a subtask of"},
+			"name":           typed("string"),
+			"task":           typed("string"),
+			"due":            dateTime(),
+			"version":        typed("integer"),
+			"priority":       {Type: "string", Description: "A capital letter, A being the highest"},
+			"completed_at":   dateTime(),
+			"extensions":     {Type: "array", Items: typed("string"), Description: "todo.txt key:value pairs that have no field of their own"},
+			"list":           schemaRef("List"),
+			"tags":           arrayOf(typed("string")),
+			"comments_count": typed("integer"),
+			"subtasks":       arrayOf(schemaRef("Todo")),
+			"etag":           {Type: "string", Description: "For the If-Match header, sent even when other fields are picked"},
+		}, Required: []string{"id", "name", "task", "version"}},
+		"TodoV2": {Type: "object", Properties: map[string]*apiSchema{
+			"id":           int64Schema(),
+			"list_id":      nullable(int64Schema()),
+			"parent_id":    nullable(int64Schema()),
+			"name":         typed("string"),
+			"task":         typed("string"),
+			"due":          nullable(dateTime()),
+			"priority":     nullable(typed("string")),
+			"completed_at": nullable(dateTime()),
+			"version":      typed("integer"),
+			"created_at":   dateTime(),
+			"updated_at":   dateTime(),
+			"etag":         {Type: "string", Description: "For the If-Match and If-None-Match headers"},
+		}, Required: []string{"completed_at", "created_at", "due", "etag", "id", "list_id", "name", "parent_id", "priority", "task", "updated_at", "version"}},
+		"TodoInput": object(map[string]*apiSchema{
+			"list_id":   nullable(int64Schema()),
+			"parent_id": nullable(int64Schema()),
+			"name":      typed("string"),
+			"task":      typed("string"),
+			"due":       nullable(dateTime()),
+		}),
+		"JSONPatch": arrayOf(object(map[string]*apiSchema{
+			"op":    enum("add", "remove", "replace", "test"),
+			"path":  typed("string"),
+			"from":  typed("string"),
+			"value": {},
+		}, "op", "path")),
+		"BatchOperation": object(map[string]*apiSchema{
+			"op":      enum("create", "update", "delete"),
+			"id":      int64Schema(),
+			"version": nullable(typed("integer")),
+			"todo":    schemaRef("TodoInput"),
+		}, "op"),
+		"BatchResult": {Type: "object", Properties: map[string]*apiSchema{
+			"index":  typed("integer"),
+			"op":     typed("string"),
+			"status": typed("integer"),
+			"todo":   schemaRef("Todo"),
+			"error":  typed("string"),
+		}, Required: []string{"index", "op", "status"}},
+		"ImportResult": {Type: "object", Properties: map[string]*apiSchema{
+			"dry_run":  typed("boolean"),
+			"valid":    typed("integer"),
+			"invalid":  typed("integer"),
+			"imported": typed("integer"),
+			"errors":   {Type: "array", Items: &apiSchema{Type: "object"}, Description: "The line and field errors of each invalid row"},
+		}, Required: []string{"dry_run", "invalid", "valid"}},
+		"Comment": {Type: "object", Properties: map[string]*apiSchema{
+			"id":         int64Schema(),
+			"todo_id":    int64Schema(),
+			"user_id":    nullable(int64Schema()),
+			"body":       typed("string"),
+			"created_at": dateTime(),
+		}, Required: []string{"body", "created_at", "id", "todo_id", "user_id"}},
+		"List": {Type: "object", Properties: map[string]*apiSchema{
+			"id":         int64Schema(),
+			"created_at": dateTime(),
+			"name":       typed("string"),
+			"version":    typed("integer"),
+		}, Required: []string{"created_at", "id", "name", "version"}},
+		"User": {Type: "object", Properties: map[string]*apiSchema{
+			"id":             int64Schema(),
+			"created_at":     dateTime(),
+			"name":           typed("string"),
+			"email":          typed("string"),
+			"phone":          typed("string"),
+			"phone_verified": typed("boolean"),
+			"sms_reminders":  typed("boolean"),
+		}, Required: []string{"created_at", "email", "id", "name", "phone_verified", "sms_reminders"}},
+		"Organization": {Type: "object", Properties: map[string]*apiSchema{
+			"id":         int64Schema(),
+			"created_at": dateTime(),
+			"name":       typed("string"),
+			"role":       enum("owner", "admin", "member"),
+			"version":    typed("integer"),
+		}, Required: []string{"created_at", "id", "name", "version"}},
+		"Membership": {Type: "object", Properties: map[string]*apiSchema{
+			"org_id":     int64Schema(),
+			"user_id":    int64Schema(),
+			"name":       typed("string"),
+			"email":      typed("string"),
+			"role":       enum("owner", "admin", "member"),
+			"created_at": dateTime(),
+		}, Required: []string{"created_at", "org_id", "role", "user_id"}},
+		"Invitation": {Type: "object", Properties: map[string]*apiSchema{
+			"org_id":     int64Schema(),
+			"email":      typed("string"),
+			"role":       enum("admin", "member"),
+			"invited_by": int64Schema(),
+			"expiry":     dateTime(),
+		}, Required: []string{"email", "expiry", "invited_by", "org_id", "role"}},
+		"Session": {Type: "object", Properties: map[string]*apiSchema{
+			"id":           typed("string"),
+			"created_at":   dateTime(),
+			"last_used_at": dateTime(),
+			"user_agent":   typed("string"),
+			"ip":           typed("string"),
+			"current":      typed("boolean"),
+		}, Required: []string{"created_at", "current", "id", "ip", "last_used_at", "user_agent"}},
+		"CalendarFeed": {Type: "object", Properties: map[string]*apiSchema{
+			"token":      typed("string"),
+			"org_id":     int64Schema(),
+			"created_at": dateTime(),
+		}, Required: []string{"created_at", "org_id", "token"}},
+		"DAVPassword": {Type: "object", Properties: map[string]*apiSchema{
+			"password":   typed("string"),
+			"org_id":     int64Schema(),
+			"created_at": dateTime(),
+		}, Required: []string{"created_at", "org_id", "password"}},
+		"TokenPair": envelopeOf(map[string]*apiSchema{
+			"authentication_token": envelopeOf(map[string]*apiSchema{
+				"token":      typed("string"),
+				"token_type": enum("Bearer"),
+				"expiry":     dateTime(),
+			}),
+			"refresh_token": envelopeOf(map[string]*apiSchema{
+				"token":  typed("string"),
+				"expiry": dateTime(),
+			}),
+		}),
+	}
+}
+
+// The apiResponses() function returns the error responses that operations
+// share
+func apiResponses() map[string]*apiResponse {
+	errorResponse := func(description string) *apiResponse {
+		return jsonResponse(description, schemaRef("Error"))
+	}
+	return map[string]*apiResponse{
+		"BadRequest":           errorResponse("The request is malformed"),
+		"Unauthorized":         errorResponse("The access token is missing, invalid or expired"),
+		"Forbidden":            errorResponse("The user is not a member of the organization, or lacks the role"),
+		"NotFound":             errorResponse("The resource could not be found"),
+		"EditConflict":         errorResponse("The record was changed by someone else"),
+		"IdempotencyKeyInUse":  errorResponse("A request with the same Idempotency-Key is still being processed"),
+		"PayloadTooLarge":      errorResponse("The request body is too large"),
+		"UnsupportedMediaType": errorResponse("The Content-Type of the request body is not supported"),
+		"TooManyRequests":      errorResponse("The rate limit was exceeded"),
+		"ServerError":          errorResponse("The server could not process the request"),
+		"ValidationFailed":     jsonResponse("The request failed validation", schemaRef("ValidationError")),
+	}
+}
+
+// The apiOperations() function describes every route, keyed by method and
+// httprouter pattern
+func apiOperations() map[string]*apiOperation {
+	todoID := pathParam("id", int64Schema())
+	idempotencyKey := &apiParameter{
+		Name: "Idempotency-Key", In: "header", Schema: typed("string"),
+		Description: "Makes a retry safe: the first response for the key is kept for 24 hours and replayed, with an Idempotent-Replayed header, for the same request",
+	}
+	search := []*apiParameter{
+		query("name", "Match todos whose name contains the words", typed("string")),
+		query("task", "Match todos whose task contains the words", typed("string")),
+		query("sort", "The field to sort by, descending with a leading -", enum("id", "name", "task", "-id", "-name", "-task")),
+	}
+	projection := []*apiParameter{
+		query("fields", "Comma separated fields to send", typed("string")),
+		query("include", "Comma separated relations to send: list, tags, comments_count, subtasks", typed("string")),
+	}
+	dryRun := query("dry_run", "Only check the rows", typed("boolean"))
+	importResponses := func() map[string]*apiResponse {
+		return map[string]*apiResponse{
+			"200": jsonResponse("The dry run result", schemaRef("ImportResult")),
+			"201": jsonResponse("The todos were imported", schemaRef("ImportResult")),
+			"400": errorRef("BadRequest"),
+			"409": errorRef("EditConflict"),
+			"413": errorRef("PayloadTooLarge"),
+			"415": errorRef("UnsupportedMediaType"),
+			"422": jsonResponse("Some rows are invalid and nothing was imported", schemaRef("ImportResult")),
+		}
+	}
+	todoEnvelope := envelopeOf(map[string]*apiSchema{"todo": schemaRef("Todo")})
+	todoV2Envelope := envelopeOf(map[string]*apiSchema{"todo": schemaRef("TodoV2")})
+	davObject := []*apiParameter{pathParam("list", typed("string")), pathParam("object", typed("string"))}
+	davList := []*apiParameter{pathParam("list", typed("string"))}
+	davMultistatus := fileResponse("A WebDAV multistatus", "application/xml")
+
+	return map[string]*apiOperation{
+		"GET /v1/healthcheck": {
+			OperationID: "healthcheck", Summary: "Show application information", Tags: []string{"health"},
+			Responses: map[string]*apiResponse{"200": jsonResponse("The application is available", envelopeOf(map[string]*apiSchema{
+				"status": typed("string"),
+				"system_info": envelopeOf(map[string]*apiSchema{
+					"environment": typed("string"),
+					"version":     typed("string"),
+				}),
+			}))},
+		},
+		"GET /v1/openapi.json": {
+			OperationID: "openapi", Summary: "This document", Tags: []string{"health"},
+			Responses: map[string]*apiResponse{"200": jsonResponse("The OpenAPI document", typed("object"))},
+		},
+
+		"GET /v1/todoInfo": {
+			OperationID: "listTodoInfo", Deprecated: true, Summary: "List the todos that match a search, a page at a time", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: append(append([]*apiParameter{
+				query("page", "The page to get", typed("integer")),
+				query("page_size", "The number of todos on a page", typed("integer")),
+				query("cursor", "The next_cursor of the previous page", typed("string")),
+				query("total", "How to count the matching todos", enum("exact", "estimate", "none")),
+			}, search...), projection...),
+			Responses: map[string]*apiResponse{
+				"200": {Description: "A page of todos, also as CSV or NDJSON with a Link header to the next page", Content: map[string]apiMediaType{
+					"application/json": {Schema: envelopeOf(map[string]*apiSchema{
+						"todos":    arrayOf(schemaRef("Todo")),
+						"metadata": schemaRef("Metadata"),
+					})},
+					csvMediaType:    {Schema: typed("string")},
+					ndjsonMediaType: {Schema: typed("string")},
+				}},
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/todoInfo": {
+			OperationID: "createTodoInfo", Deprecated: true, Summary: "Create a todo", Tags: []string{"todos"}, auth: authOrg,
+			Parameters:  []*apiParameter{idempotencyKey},
+			RequestBody: jsonBody(schemaRef("TodoInput")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The todo, with its ETag and Location headers", todoEnvelope),
+				"400": errorRef("BadRequest"),
+				"409": errorRef("IdempotencyKeyInUse"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/todoInfo/batch": {
+			OperationID: "batchTodoInfo", Summary: "Create, update and delete many todos in one request", Tags: []string{"todos"}, auth: authOrg,
+			RequestBody: jsonBody(object(map[string]*apiSchema{
+				"mode":       enum("atomic", "best_effort"),
+				"operations": arrayOf(schemaRef("BatchOperation")),
+			}, "operations")),
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The result of each operation", envelopeOf(map[string]*apiSchema{
+					"mode":    enum("atomic", "best_effort"),
+					"results": arrayOf(schemaRef("BatchResult")),
+				})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/todoInfo/import": {
+			OperationID: "importTodoInfo", Summary: "Add todos from a CSV or NDJSON file", Tags: []string{"files"}, auth: authOrg,
+			Parameters: []*apiParameter{
+				dryRun,
+				query("map", "Comma separated column=field pairs for CSV headers that are not field names", typed("string")),
+			},
+			RequestBody: fileBody(csvMediaType, ndjsonMediaType),
+			Responses:   importResponses(),
+		},
+		"POST /v1/todoInfo/todotxt": {
+			OperationID: "importTodoTxt", Summary: "Add todos from a todo.txt file", Tags: []string{"files"}, auth: authOrg,
+			Parameters:  []*apiParameter{dryRun},
+			RequestBody: fileBody(todoTxtMediaType),
+			Responses:   importResponses(),
+		},
+		"GET /v1/todoInfo.ics": {
+			OperationID: "calendarFeed", Summary: "The todos as an iCalendar feed, read with the token of a calendar feed", Tags: []string{"files"},
+			Parameters: []*apiParameter{{Name: "token", In: "query", Required: true, Schema: typed("string")}},
+			Responses: map[string]*apiResponse{
+				"200": fileResponse("The todos as VTODOs", calendarMediaType),
+				"404": errorRef("NotFound"),
+			},
+		},
+		"POST /v1/todoInfo.ics": {
+			OperationID: "importCalendar", Summary: "Update the todos whose UID matches and add the rest from an .ics file", Tags: []string{"files"}, auth: authOrg,
+			Parameters:  []*apiParameter{dryRun},
+			RequestBody: fileBody(calendarMediaType),
+			Responses:   importResponses(),
+		},
+		"GET /v1/todoInfo/export": {
+			OperationID: "exportTodoInfo", Summary: "Download every matching todo as NDJSON or CSV", Tags: []string{"files"}, auth: authOrg,
+			Parameters: search,
+			Responses: map[string]*apiResponse{
+				"200": fileResponse("The todos, in the format picked by the Accept header", ndjsonMediaType, csvMediaType),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"GET /v1/todoInfo/todotxt": {
+			OperationID: "exportTodoTxt", Summary: "Download the todos as a todo.txt file", Tags: []string{"files"}, auth: authOrg,
+			Parameters: search,
+			Responses: map[string]*apiResponse{
+				"200": fileResponse("The todos, one per line", todoTxtMediaType),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"GET /v1/todoInfo/:id": {
+			OperationID: "showTodoInfo", Deprecated: true, Summary: "Show a todo", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: append([]*apiParameter{todoID}, projection...),
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The todo, with its ETag header", todoEnvelope),
+				"304": {Description: "The todo still matches If-None-Match"},
+				"404": errorRef("NotFound"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"PATCH /v1/todoInfo/:id": {
+			OperationID: "updateTodoInfo", Deprecated: true, Summary: "Change some fields of a todo, only if it still matches If-Match when that is sent", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			RequestBody: &apiRequestBody{Required: true, Content: map[string]apiMediaType{
+				"application/json":  {Schema: schemaRef("TodoInput")},
+				mergePatchMediaType: {Schema: schemaRef("TodoInput")},
+				jsonPatchMediaType:  {Schema: schemaRef("JSONPatch")},
+			}},
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The updated todo, with its ETag header", todoEnvelope),
+				"400": errorRef("BadRequest"),
+				"404": errorRef("NotFound"),
+				"409": errorRef("EditConflict"),
+				"412": jsonResponse("The todo no longer matches If-Match", schemaRef("Error")),
+				"415": errorRef("UnsupportedMediaType"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"DELETE /v1/todoInfo/:id": {
+			OperationID: "deleteTodoInfo", Deprecated: true, Summary: "Delete a todo, only if it still matches If-Match when that is sent", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The todo was deleted"),
+				"404": errorRef("NotFound"),
+				"412": jsonResponse("The todo no longer matches If-Match", schemaRef("Error")),
+			},
+		},
+
+		"GET /v2/todos": {
+			OperationID: "listTodos", Summary: "List the todos that match a search, a page at a time", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: append([]*apiParameter{
+				query("page", "The page to get", typed("integer")),
+				query("page_size", "The number of todos on a page", typed("integer")),
+				query("cursor", "The next_cursor of the previous page", typed("string")),
+				query("total", "How to count the matching todos", enum("exact", "estimate", "none")),
+			}, search...),
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("A page of todos, with a Link header to the next page", envelopeOf(map[string]*apiSchema{
+					"todos":    arrayOf(schemaRef("TodoV2")),
+					"metadata": schemaRef("Metadata"),
+				})),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v2/todos": {
+			OperationID: "createTodo", Summary: "Create a todo", Tags: []string{"todos"}, auth: authOrg,
+			Parameters:  []*apiParameter{idempotencyKey},
+			RequestBody: jsonBody(schemaRef("TodoInput")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The todo, with its ETag and Location headers", todoV2Envelope),
+				"400": errorRef("BadRequest"),
+				"409": errorRef("IdempotencyKeyInUse"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"GET /v2/todos/:id": {
+			OperationID: "showTodo", Summary: "Show a todo", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The todo, with its ETag header", todoV2Envelope),
+				"304": {Description: "The todo still matches If-None-Match"},
+				"404": errorRef("NotFound"),
+			},
+		},
+		"PATCH /v2/todos/:id": {
+			OperationID: "updateTodo", Summary: "Change some fields of a todo, only if it still matches If-Match when that is sent", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			RequestBody: &apiRequestBody{Required: true, Content: map[string]apiMediaType{
+				"application/json":  {Schema: schemaRef("TodoInput")},
+				mergePatchMediaType: {Schema: schemaRef("TodoInput")},
+				jsonPatchMediaType:  {Schema: schemaRef("JSONPatch")},
+			}},
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The updated todo, with its ETag header", todoV2Envelope),
+				"400": errorRef("BadRequest"),
+				"404": errorRef("NotFound"),
+				"409": errorRef("EditConflict"),
+				"412": jsonResponse("The todo no longer matches If-Match", schemaRef("Error")),
+				"415": errorRef("UnsupportedMediaType"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"DELETE /v2/todos/:id": {
+			OperationID: "deleteTodo", Summary: "Delete a todo, only if it still matches If-Match when that is sent", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			Responses: map[string]*apiResponse{
+				"204": {Description: "The todo was deleted"},
+				"404": errorRef("NotFound"),
+				"412": jsonResponse("The todo no longer matches If-Match", schemaRef("Error")),
+			},
+		},
+		"GET /v2/todos/:id/comments": {
+			OperationID: "listComments", Summary: "List the comments on a todo, oldest first", Tags: []string{"todos"}, auth: authOrg,
+			Parameters: []*apiParameter{todoID},
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The comments", envelopeOf(map[string]*apiSchema{"comments": arrayOf(schemaRef("Comment"))})),
+				"404": errorRef("NotFound"),
+			},
+		},
+		"POST /v2/todos/:id/comments": {
+			OperationID: "createComment", Summary: "Comment on a todo", Tags: []string{"todos"}, auth: authOrg,
+			Parameters:  []*apiParameter{todoID},
+			RequestBody: jsonBody(object(map[string]*apiSchema{"body": typed("string")}, "body")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The comment", envelopeOf(map[string]*apiSchema{"comment": schemaRef("Comment")})),
+				"400": errorRef("BadRequest"),
+				"404": errorRef("NotFound"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+
+		"GET /v1/lists": {
+			OperationID: "listLists", Summary: "List the lists of the organization", Tags: []string{"lists"}, auth: authOrg,
+			Responses: map[string]*apiResponse{"200": jsonResponse("The lists", envelopeOf(map[string]*apiSchema{"lists": arrayOf(schemaRef("List"))}))},
+		},
+		"POST /v1/lists": {
+			OperationID: "createList", Summary: "Create a list", Tags: []string{"lists"}, auth: authOrg,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"name": typed("string")}, "name")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The list", envelopeOf(map[string]*apiSchema{"list": schemaRef("List")})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"DELETE /v1/lists/:id": {
+			OperationID: "deleteList", Summary: "Delete a list and its todos", Tags: []string{"lists"}, auth: authOrg,
+			Parameters: []*apiParameter{pathParam("id", int64Schema())},
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The list was deleted"),
+				"404": errorRef("NotFound"),
+			},
+		},
+
+		"POST /v1/users": {
+			OperationID: "registerUser", Summary: "Register a user, who gets an organization of their own", Tags: []string{"users"},
+			RequestBody: jsonBody(object(map[string]*apiSchema{
+				"name":     typed("string"),
+				"email":    typed("string"),
+				"password": typed("string"),
+			}, "name", "email", "password")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The user and their organization", envelopeOf(map[string]*apiSchema{
+					"user":         schemaRef("User"),
+					"organization": schemaRef("Organization"),
+				})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/tokens/authentication": {
+			OperationID: "createAuthenticationToken", Summary: "Log in and get an access and refresh token", Tags: []string{"tokens"},
+			RequestBody: jsonBody(object(map[string]*apiSchema{
+				"email":    typed("string"),
+				"password": typed("string"),
+			}, "email", "password")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The token pair", schemaRef("TokenPair")),
+				"400": errorRef("BadRequest"),
+				"401": errorRef("Unauthorized"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/tokens/refresh": {
+			OperationID: "refreshAuthenticationToken", Summary: "Swap a refresh token for a new token pair", Tags: []string{"tokens"},
+			RequestBody: jsonBody(object(map[string]*apiSchema{"refresh_token": typed("string")}, "refresh_token")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The new token pair", schemaRef("TokenPair")),
+				"400": errorRef("BadRequest"),
+				"401": errorRef("Unauthorized"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+
+		"GET /v1/users/me": {
+			OperationID: "showCurrentUser", Summary: "Show the current user", Tags: []string{"users"}, auth: authUser,
+			Responses: map[string]*apiResponse{"200": jsonResponse("The user", envelopeOf(map[string]*apiSchema{"user": schemaRef("User")}))},
+		},
+		"PUT /v1/users/me/phone": {
+			OperationID: "updatePhone", Summary: "Set the phone number and text it a verification code", Tags: []string{"users"}, auth: authUser,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"phone": typed("string")}, "phone")),
+			Responses: map[string]*apiResponse{
+				"202": messageResponse("The code is on its way"),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/users/me/phone/verify": {
+			OperationID: "verifyPhone", Summary: "Verify the phone number with the code", Tags: []string{"users"}, auth: authUser,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"code": typed("string")}, "code")),
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The phone number is verified"),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"PUT /v1/users/me/reminders": {
+			OperationID: "updateReminders", Summary: "Opt in or out of SMS due-date reminders", Tags: []string{"users"}, auth: authUser,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"sms": typed("boolean")}, "sms")),
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The reminder settings", envelopeOf(map[string]*apiSchema{
+					"reminders": envelopeOf(map[string]*apiSchema{"sms": typed("boolean")}),
+				})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"GET /v1/users/me/sessions": {
+			OperationID: "listSessions", Summary: "Show the active sessions of the current user", Tags: []string{"users"}, auth: authUser,
+			Responses: map[string]*apiResponse{"200": jsonResponse("The sessions", envelopeOf(map[string]*apiSchema{"sessions": arrayOf(schemaRef("Session"))}))},
+		},
+		"DELETE /v1/users/me/sessions": {
+			OperationID: "deleteOtherSessions", Summary: "Revoke every session except the current one", Tags: []string{"users"}, auth: authUser,
+			Responses: map[string]*apiResponse{"200": jsonResponse("The number of sessions revoked", envelopeOf(map[string]*apiSchema{"revoked": typed("integer")}))},
+		},
+		"DELETE /v1/users/me/sessions/:id": {
+			OperationID: "deleteSession", Summary: "Revoke a session", Tags: []string{"users"}, auth: authUser,
+			Parameters: []*apiParameter{pathParam("id", typed("string"))},
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The session was revoked"),
+				"404": errorRef("NotFound"),
+			},
+		},
+		"POST /v1/users/me/calendar-feed": {
+			OperationID: "createCalendarFeed", Summary: "Get a new secret calendar feed URL for the organization", Tags: []string{"files"}, auth: authOrg,
+			Responses: map[string]*apiResponse{"201": jsonResponse("The feed and its URL", envelopeOf(map[string]*apiSchema{
+				"calendar_feed": schemaRef("CalendarFeed"),
+				"url":           typed("string"),
+			}))},
+		},
+		"DELETE /v1/users/me/calendar-feed": {
+			OperationID: "deleteCalendarFeed", Summary: "Turn off the calendar feed URL for the organization", Tags: []string{"files"}, auth: authOrg,
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The feed was turned off"),
+				"404": errorRef("NotFound"),
+			},
+		},
+		"POST /v1/users/me/caldav-password": {
+			OperationID: "createDAVPassword", Summary: "Get a new CalDAV app password for the organization", Tags: []string{"caldav"}, auth: authOrg,
+			Responses: map[string]*apiResponse{"201": jsonResponse("The password and the URL to sync with", envelopeOf(map[string]*apiSchema{
+				"caldav_password": schemaRef("DAVPassword"),
+				"url":             typed("string"),
+			}))},
+		},
+		"DELETE /v1/users/me/caldav-password": {
+			OperationID: "deleteDAVPassword", Summary: "Log out the CalDAV clients of the organization", Tags: []string{"caldav"}, auth: authOrg,
+			Responses: map[string]*apiResponse{
+				"200": messageResponse("The password was deleted"),
+				"404": errorRef("NotFound"),
+			},
+		},
+
+		"GET " + davWellKnown: {
+			OperationID: "wellKnownCalDAV", Summary: "Redirect to the principal", Tags: []string{"caldav"},
+			Responses: map[string]*apiResponse{"301": {Description: "The principal is at /dav/"}},
+		},
+		methodPropfind + " " + davWellKnown: {
+			OperationID: "propfindWellKnownCalDAV", Summary: "Redirect to the principal", Tags: []string{"caldav"},
+			Responses: map[string]*apiResponse{"301": {Description: "The principal is at /dav/"}},
+		},
+		"OPTIONS " + davRoot: {
+			OperationID: "davOptionsRoot", Summary: "Advertise CalDAV support", Tags: []string{"caldav"},
+			Responses: map[string]*apiResponse{"200": {Description: "The DAV and Allow headers"}},
+		},
+		"OPTIONS " + davCalendars: {
+			OperationID: "davOptionsCalendars", Summary: "Advertise CalDAV support", Tags: []string{"caldav"},
+			Responses: map[string]*apiResponse{"200": {Description: "The DAV and Allow headers"}},
+		},
+		"OPTIONS " + davCalendars + ":list/": {
+			OperationID: "davOptionsCalendar", Summary: "Advertise CalDAV support", Tags: []string{"caldav"},
+			Parameters: davList,
+			Responses:  map[string]*apiResponse{"200": {Description: "The DAV and Allow headers"}},
+		},
+		"OPTIONS " + davCalendars + ":list/:object": {
+			OperationID: "davOptionsCalendarObject", Summary: "Advertise CalDAV support", Tags: []string{"caldav"},
+			Parameters: davObject,
+			Responses:  map[string]*apiResponse{"200": {Description: "The DAV and Allow headers"}},
+		},
+		methodPropfind + " " + davRoot: {
+			OperationID: "propfindPrincipal", Summary: "The principal and its calendar home", Tags: []string{"caldav"}, auth: authDAV,
+			Responses: map[string]*apiResponse{"207": davMultistatus},
+		},
+		methodPropfind + " " + davCalendars: {
+			OperationID: "propfindCalendarHome", Summary: "The calendars (Depth: 1)", Tags: []string{"caldav"}, auth: authDAV,
+			Responses: map[string]*apiResponse{"207": davMultistatus},
+		},
+		methodPropfind + " " + davCalendars + ":list/": {
+			OperationID: "propfindCalendar", Summary: "A calendar and its todos (Depth: 1)", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters: davList,
+			Responses:  map[string]*apiResponse{"207": davMultistatus, "404": {Description: "No such calendar"}},
+		},
+		methodReport + " " + davCalendars + ":list/": {
+			OperationID: "reportCalendar", Summary: "calendar-query, calendar-multiget and sync-collection", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters: davList,
+			Responses:  map[string]*apiResponse{"207": davMultistatus, "403": {Description: "The sync token or report is not supported"}},
+		},
+		methodPropfind + " " + davCalendars + ":list/:object": {
+			OperationID: "propfindCalendarObject", Summary: "The properties of a todo", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters: davObject,
+			Responses:  map[string]*apiResponse{"207": davMultistatus, "404": {Description: "No such todo"}},
+		},
+		"GET " + davCalendars + ":list/:object": {
+			OperationID: "getCalendarObject", Summary: "A todo as a VTODO", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters: davObject,
+			Responses:  map[string]*apiResponse{"200": fileResponse("The todo", calendarMediaType), "404": {Description: "No such todo"}},
+		},
+		"PUT " + davCalendars + ":list/:object": {
+			OperationID: "putCalendarObject", Summary: "Create or replace a todo (If-Match / If-None-Match with the ETag)", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters:  davObject,
+			RequestBody: fileBody(calendarMediaType),
+			Responses: map[string]*apiResponse{
+				"201": {Description: "The todo was created"},
+				"204": {Description: "The todo was replaced"},
+				"403": {Description: "The body is not a calendar with one VTODO"},
+				"412": {Description: "The todo does not match If-Match or If-None-Match"},
+			},
+		},
+		"DELETE " + davCalendars + ":list/:object": {
+			OperationID: "deleteCalendarObject", Summary: "Delete a todo", Tags: []string{"caldav"}, auth: authDAV,
+			Parameters: davObject,
+			Responses: map[string]*apiResponse{
+				"204": {Description: "The todo was deleted"},
+				"404": {Description: "No such todo"},
+				"412": {Description: "The todo does not match If-Match"},
+			},
+		},
+
+		"GET /v1/orgs": {
+			OperationID: "listOrganizations", Summary: "Show the organizations of the current user", Tags: []string{"organizations"}, auth: authUser,
+			Responses: map[string]*apiResponse{"200": jsonResponse("The organizations with the user's role", envelopeOf(map[string]*apiSchema{
+				"organizations": arrayOf(schemaRef("Organization")),
+			}))},
+		},
+		"POST /v1/orgs": {
+			OperationID: "createOrganization", Summary: "Create an organization", Tags: []string{"organizations"}, auth: authUser,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"name": typed("string")}, "name")),
+			Responses: map[string]*apiResponse{
+				"201": jsonResponse("The organization", envelopeOf(map[string]*apiSchema{"organization": schemaRef("Organization")})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"GET /v1/orgs/:id/members": {
+			OperationID: "listOrganizationMembers", Summary: "Show the members of an organization", Tags: []string{"organizations"}, auth: authUser,
+			Parameters: []*apiParameter{pathParam("id", int64Schema())},
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The members", envelopeOf(map[string]*apiSchema{"members": arrayOf(schemaRef("Membership"))})),
+				"403": errorRef("Forbidden"),
+				"404": errorRef("NotFound"),
+			},
+		},
+		"POST /v1/orgs/:id/invitations": {
+			OperationID: "createInvitation", Summary: "Invite someone to an organization by email", Tags: []string{"organizations"}, auth: authUser,
+			Parameters: []*apiParameter{pathParam("id", int64Schema())},
+			RequestBody: jsonBody(object(map[string]*apiSchema{
+				"email": typed("string"),
+				"role":  enum("admin", "member"),
+			}, "email", "role")),
+			Responses: map[string]*apiResponse{
+				"202": jsonResponse("The invitation is on its way", envelopeOf(map[string]*apiSchema{"invitation": schemaRef("Invitation")})),
+				"400": errorRef("BadRequest"),
+				"403": errorRef("Forbidden"),
+				"404": errorRef("NotFound"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+		"POST /v1/invitations/accept": {
+			OperationID: "acceptInvitation", Summary: "Join an organization with an invitation token", Tags: []string{"organizations"}, auth: authUser,
+			RequestBody: jsonBody(object(map[string]*apiSchema{"token": typed("string")}, "token")),
+			Responses: map[string]*apiResponse{
+				"200": jsonResponse("The new membership", envelopeOf(map[string]*apiSchema{"membership": schemaRef("Membership")})),
+				"400": errorRef("BadRequest"),
+				"422": errorRef("ValidationFailed"),
+			},
+		},
+	}
+}
+
+// openAPIHandler for the "GET /v1/openapi.json" endpoint
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	js, err := marshalJSON(app.openapi, app.compactJSON(r), "")
+	if err != nil {
+		app.serverErrorResponse(w, r, fmt.Errorf("openapi: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(js, '\n'))
+}
+
+// The match() method returns the operation that answers a request, or nil if
+// the router will answer 404 or 405. Static segments win over parameters, as
+// they do in the router
+func (doc *apiDocument) match(method string, path string) *apiOperation {
+	segments := strings.Split(path, "/")
+	var best *apiOperation
+	bestParams := len(segments)
+	for candidate, item := range doc.Paths {
+		parts := strings.Split(candidate, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		op := item.WebDAV[method]
+		if slot := item.operation(method); slot != nil {
+			op = *slot
+		}
+		if op == nil {
+			continue
+		}
+		params := 0
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && segments[i] != "" {
+				params++
+				continue
+			}
+			if part != segments[i] {
+				params = -1
+				break
+			}
+		}
+		if params >= 0 && (best == nil || params < bestParams) {
+			best, bestParams = op, params
+		}
+	}
+	return best
+}
+
+// The checkQuery() method adds an error for every query parameter that the
+// operation does not take or that does not fit its schema
+func (op *apiOperation) checkQuery(qs url.Values, errs map[string]string) {
+	declared := make(map[string]*apiParameter)
+	for _, param := range op.Parameters {
+		if param.In != "query" {
+			continue
+		}
+		declared[param.Name] = param
+		if param.Required && qs.Get(param.Name) == "" {
+			errs[param.Name] = "must be provided"
+		}
+	}
+	for name, values := range qs {
+		param, ok := declared[name]
+		if !ok {
+			errs[name] = "is not a parameter of this endpoint"
+			continue
+		}
+		for _, value := range values {
+			switch {
+			case param.Schema.Type == "integer":
+				if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+					errs[name] = "must be a valid integer value"
+				}
+			case param.Schema.Type == "boolean":
+				if _, err := strconv.ParseBool(value); err != nil {
+					errs[name] = "must be true or false"
+				}
+			case len(param.Schema.Enum) > 0:
+				if !validator.In(value, param.Schema.Enum...) {
+					errs[name] = "must be one of " + strings.Join(param.Schema.Enum, ", ")
+				}
+			}
+		}
+	}
+}
+
+// The bodySchema() method returns the schema of a JSON request body with the
+// Content-Type, or nil if the body is not JSON or not known. The handlers
+// check other bodies themselves
+func (op *apiOperation) bodySchema(contentType string) *apiSchema {
+	if op.RequestBody == nil {
+		return nil
+	}
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil
+		}
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	return op.RequestBody.Content[mediaType].Schema
+}
+
+// The jsonType() function returns the JSON Schema type of a value decoded
+// with UseNumber()
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// The check() method adds an error for every part of value that does not fit
+// the schema. Errors are keyed by the path to the field, such as
+// "operations.0.op", like the validation errors of the handlers
+func (doc *apiDocument) check(s *apiSchema, value interface{}, field string, errs map[string]string) {
+	if s.Ref != "" {
+		s = doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	key := field
+	if key == "" {
+		key = "body"
+	}
+	join := func(name string) string {
+		if field == "" {
+			return name
+		}
+		return field + "." + name
+	}
+
+	if s.Type != nil {
+		types, ok := s.Type.([]string)
+		if !ok {
+			types = []string{s.Type.(string)}
+		}
+		got := jsonType(value)
+		if !validator.In(got, types...) && !(got == "integer" && validator.In("number", types...)) {
+			errs[key] = "must be of type " + strings.Join(types, " or ")
+			return
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		if len(s.Enum) > 0 && !validator.In(value, s.Enum...) {
+			errs[key] = "must be one of " + strings.Join(s.Enum, ", ")
+		}
+		if _, err := time.Parse(time.RFC3339, value); s.Format == "date-time" && err != nil {
+			errs[key] = "must be an RFC 3339 date and time"
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				errs[join(name)] = "must be provided"
+			}
+		}
+		for name, v := range value {
+			if property, ok := s.Properties[name]; ok {
+				doc.check(property, v, join(name), errs)
+				continue
+			}
+			switch additional := s.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					errs[join(name)] = "is not a known field"
+				}
+			case *apiSchema:
+				doc.check(additional, v, join(name), errs)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, v := range value {
+				doc.check(s.Items, v, join(strconv.Itoa(i)), errs)
+			}
+		}
+	}
+}
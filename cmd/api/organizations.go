@@ -0,0 +1,206 @@
+// Filename: cmd/api/organizations.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// How long an invitation to an organization stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
+// createOrganizationHandler for the "POST /v1/orgs" endpoint
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	org := &data.Organization{Name: input.Name}
+	v := validator.New()
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The creator becomes the owner
+	err = app.models.Organizations.Insert(org, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%d", org.ID))
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrganizationsHandler for the "GET /v1/orgs" endpoint
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs, err := app.models.Organizations.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"organizations": orgs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The orgMembership() method reads the organization id from the URL and
+// returns the user's membership of it. It writes the error response itself
+// and returns nil if the user is not a member
+func (app *application) orgMembership(w http.ResponseWriter, r *http.Request) *data.Membership {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	membership, err := app.models.Organizations.GetMembership(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return membership
+}
+
+// listOrganizationMembersHandler for the "GET /v1/orgs/:id/members" endpoint
+func (app *application) listOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.orgMembership(w, r)
+	if membership == nil {
+		return
+	}
+	members, err := app.models.Organizations.GetMembers(membership.OrgID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInvitationHandler for the "POST /v1/orgs/:id/invitations" endpoint.
+// The invitation token is only ever sent to the invited email address
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	membership := app.orgMembership(w, r)
+	if membership == nil {
+		return
+	}
+	if !membership.CanManage() {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	invitation := &data.Invitation{
+		OrgID:     membership.OrgID,
+		Email:     input.Email,
+		Role:      input.Role,
+		InvitedBy: membership.UserID,
+	}
+	v := validator.New()
+	if data.ValidateInvitation(v, invitation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Look up the names used in the email
+	inviter, err := app.models.Users.Get(membership.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	org, err := app.models.Organizations.Get(membership.OrgID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Organizations.NewInvitation(invitation, invitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Send the email without making the client wait for the SMTP server
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"inviterName": inviter.Name,
+			"orgName":     org.Name,
+			"role":        invitation.Role,
+			"token":       invitation.Plaintext,
+			"expiry":      invitation.Expiry.Format(time.RFC1123),
+		}
+		err := app.mailer.Send(invitation.Email, "org_invitation.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler for the "POST /v1/invitations/accept" endpoint
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateInvitationTokenPlaintext(v, input.Token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The invitation has to match the email address of the user
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	membership, err := app.models.Organizations.AcceptInvitation(input.Token, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAlreadyMember):
+			v.AddError("token", "you are already a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"membership": membership}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,223 @@
+// Filename: cmd/api/patch.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+)
+
+// The media types of the patch formats we accept on top of plain JSON
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// errPatchTestFailed is returned when a JSON Patch "test" operation fails
+var errPatchTestFailed = errors.New("patch test operation failed")
+
+// A patchError is a patch that cannot be applied to the todo
+type patchError struct {
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+// A jsonPatchOperation is one operation of an RFC 6902 JSON Patch document
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+	From  string          `json:"from"`
+}
+
+// The todoDocument() function returns the JSON document that patches are
+// applied to. Nullable fields are always present so they can be tested and
+// replaced
+func todoDocument(todo *data.Todo) (map[string]interface{}, error) {
+	js, err := json.Marshal(map[string]interface{}{
+		"id":        todo.ID,
+		"list_id":   todo.ListID,
+		"parent_id": todo.ParentID,
+		"name":      todo.Name,
+		"task":      todo.Task,
+		"due":       todo.Due,
+		"version":   todo.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Round trip through JSON so the values have the types that decoded
+	// patch values have
+	var doc map[string]interface{}
+	err = json.Unmarshal(js, &doc)
+	return doc, err
+}
+
+// The applyMergePatch() function applies an RFC 7396 merge patch to the
+// target. A null value removes the member
+func applyMergePatch(target map[string]interface{}, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		patchObject, isObject := value.(map[string]interface{})
+		if !isObject {
+			target[key] = value
+			continue
+		}
+		targetObject, ok := target[key].(map[string]interface{})
+		if !ok {
+			targetObject = make(map[string]interface{})
+		}
+		applyMergePatch(targetObject, patchObject)
+		target[key] = targetObject
+	}
+}
+
+// The applyJSONPatch() function applies the add, remove, replace and test
+// operations of an RFC 6902 JSON Patch to the document. Our documents are
+// flat, so paths address a top level member
+func applyJSONPatch(doc map[string]interface{}, operations []jsonPatchOperation) error {
+	for i, op := range operations {
+		key, err := jsonPointerKey(op.Path)
+		if err != nil {
+			return &patchError{fmt.Sprintf("operation %d: %s", i, err)}
+		}
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return &patchError{fmt.Sprintf("operation %d: %s needs a value", i, op.Op)}
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return &patchError{fmt.Sprintf("operation %d: invalid value", i)}
+			}
+		}
+		current, exists := doc[key]
+		switch op.Op {
+		case "add":
+			doc[key] = value
+		case "remove", "replace":
+			if !exists {
+				return &patchError{fmt.Sprintf("operation %d: path %s does not exist", i, op.Path)}
+			}
+			if op.Op == "remove" {
+				delete(doc, key)
+			} else {
+				doc[key] = value
+			}
+		case "test":
+			if !exists || !reflect.DeepEqual(current, value) {
+				return fmt.Errorf("operation %d: %w", i, errPatchTestFailed)
+			}
+		default:
+			return &patchError{fmt.Sprintf("operation %d: op must be add, remove, replace or test", i)}
+		}
+	}
+	return nil
+}
+
+// The jsonPointerKey() function returns the member a JSON Pointer such as
+// "/name" refers to
+func jsonPointerKey(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", fmt.Errorf("path %q must point to a field of the todo", pointer)
+	}
+	key := strings.TrimPrefix(pointer, "/")
+	// Unescape in the order RFC 6901 asks for
+	key = strings.ReplaceAll(key, "~1", "/")
+	key = strings.ReplaceAll(key, "~0", "~")
+	return key, nil
+}
+
+// The updateTodoFromDocument() function copies a patched document back into
+// the todo. Members missing from the document are cleared
+func updateTodoFromDocument(todo *data.Todo, doc map[string]interface{}) error {
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var patched struct {
+		ID       *int64     `json:"id"`
+		ListID   *int64     `json:"list_id"`
+		ParentID *int64     `json:"parent_id"`
+		Name     *string    `json:"name"`
+		Task     *string    `json:"task"`
+		Due      *time.Time `json:"due"`
+		Version  *int32     `json:"version"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return &patchError{fmt.Sprintf("%s has the wrong type", unmarshalTypeError.Field)}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return &patchError{fmt.Sprintf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))}
+		default:
+			return &patchError{err.Error()}
+		}
+	}
+	// The id and version belong to the server
+	if patched.ID == nil || *patched.ID != todo.ID {
+		return &patchError{"id cannot be changed"}
+	}
+	if patched.Version == nil || *patched.Version != todo.Version {
+		return &patchError{"version cannot be changed"}
+	}
+	todo.ListID = patched.ListID
+	todo.ParentID = patched.ParentID
+	todo.Due = patched.Due
+	todo.Name = ""
+	if patched.Name != nil {
+		todo.Name = *patched.Name
+	}
+	todo.Task = ""
+	if patched.Task != nil {
+		todo.Task = *patched.Task
+	}
+	return nil
+}
+
+// The applyTodoPatch() method reads a merge patch or JSON Patch from the
+// request body and applies it to the todo
+func (app *application) applyTodoPatch(w http.ResponseWriter, r *http.Request, mediaType string, todo *data.Todo) error {
+	doc, err := todoDocument(todo)
+	if err != nil {
+		return err
+	}
+	switch mediaType {
+	case mergePatchMediaType:
+		var patch map[string]interface{}
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+		applyMergePatch(doc, patch)
+	case jsonPatchMediaType:
+		var operations []jsonPatchOperation
+		err = app.readJSON(w, r, &operations)
+		if err != nil {
+			return err
+		}
+		err = applyJSONPatch(doc, operations)
+		if err != nil {
+			return err
+		}
+	}
+	return updateTodoFromDocument(todo, doc)
+}
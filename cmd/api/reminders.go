@@ -0,0 +1,48 @@
+// Filename: cmd/api/reminders.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The sendReminders() method texts the creators of todos that are due soon.
+// It checks for due todos once per interval until ctx is done
+func (app *application) sendReminders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Run each round as background work so a shutdown does not cut
+			// off reminders that were already claimed
+			app.background(app.sendDueReminders)
+		}
+	}
+}
+
+// The sendDueReminders() method claims the todos that are due soon and texts
+// their creators. A reminder that could not be sent is released again
+func (app *application) sendDueReminders() {
+	reminders, err := app.models.Reminders.ClaimDue(app.config.sms.reminderLead, 100)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	for _, reminder := range reminders {
+		message := fmt.Sprintf("Reminder: %q is due %s", reminder.Name, reminder.Due.UTC().Format("Mon Jan 2 15:04 MST"))
+		err := app.sms.Send(reminder.Phone, message)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			// Give the claim back so the next round tries again, as long as
+			// the todo is still due within the lead time
+			if err := app.models.Reminders.Release(reminder.TodoID); err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
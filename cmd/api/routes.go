@@ -8,18 +8,106 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	//Create a new  httprouter ruter instance
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todoInfo", app.listTodoInfoHandler)
+	// Every route notes its pattern for the request metrics, and is kept
+	// for the OpenAPI document
+	var registered []apiRoute
+	handle := func(method string, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.recordRoute(pattern, handler))
+		registered = append(registered, apiRoute{method: method, pattern: pattern})
+	}
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
+	// The todoInfo endpoints that /v2/todos replaces say so in their headers
+	handle(http.MethodGet, "/v1/todoInfo", app.requireOrgMember(app.deprecated("/v1/todoInfo", "/v2/todos", app.listTodoInfoHandler)))
 
-	router.HandlerFunc(http.MethodPost, "/v1/todoInfo", app.createTodoInfoHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todoInfo/:id", app.showTodoInfoHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/todoInfo/:id", app.updateTodoInfoHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/todoInfo/:id", app.deleteTodoInfoHandler)
+	handle(http.MethodPost, "/v1/todoInfo", app.requireOrgMember(app.deprecated("/v1/todoInfo", "/v2/todos", app.idempotent(app.createTodoInfoHandler))))
+	handle(http.MethodPost, "/v1/todoInfo/batch", app.requireOrgMember(app.batchTodoInfoHandler))
+	handle(http.MethodPost, "/v1/todoInfo/import", app.requireOrgMember(app.importTodoInfoHandler))
+	handle(http.MethodPost, "/v1/todoInfo/todotxt", app.requireOrgMember(app.importTodoTxtHandler))
+	// The feed is read by calendar apps with the token in its URL, not logins
+	handle(http.MethodGet, "/v1/todoInfo.ics", app.calendarFeedHandler)
+	handle(http.MethodPost, "/v1/todoInfo.ics", app.requireOrgMember(app.importCalendarHandler))
+	// httprouter cannot have GET /v1/todoInfo/export or /v1/todoInfo/todotxt
+	// next to /v1/todoInfo/:id, so those come in through the :id route
+	byID := map[string]http.HandlerFunc{
+		"export":  app.exportTodoInfoHandler,
+		"todotxt": app.exportTodoTxtHandler,
+	}
+	handle(http.MethodGet, "/v1/todoInfo/:id", app.requireOrgMember(app.byIDParam(app.deprecated("/v1/todoInfo", "/v2/todos", app.showTodoInfoHandler), byID)))
+	for name := range byID {
+		registered = append(registered, apiRoute{method: http.MethodGet, pattern: "/v1/todoInfo/" + name})
+	}
+	handle(http.MethodPatch, "/v1/todoInfo/:id", app.requireOrgMember(app.deprecated("/v1/todoInfo", "/v2/todos", app.updateTodoInfoHandler)))
+	handle(http.MethodDelete, "/v1/todoInfo/:id", app.requireOrgMember(app.deprecated("/v1/todoInfo", "/v2/todos", app.deleteTodoInfoHandler)))
 
-	return router
+	handle(http.MethodGet, "/v2/todos", app.requireOrgMember(app.listTodosV2Handler))
+	handle(http.MethodPost, "/v2/todos", app.requireOrgMember(app.idempotent(app.createTodoV2Handler)))
+	handle(http.MethodGet, "/v2/todos/:id", app.requireOrgMember(app.showTodoV2Handler))
+	handle(http.MethodPatch, "/v2/todos/:id", app.requireOrgMember(app.updateTodoV2Handler))
+	handle(http.MethodDelete, "/v2/todos/:id", app.requireOrgMember(app.deleteTodoV2Handler))
+	handle(http.MethodGet, "/v2/todos/:id/comments", app.requireOrgMember(app.listCommentsHandler))
+	handle(http.MethodPost, "/v2/todos/:id/comments", app.requireOrgMember(app.createCommentHandler))
+
+	handle(http.MethodGet, "/v1/lists", app.requireOrgMember(app.listListsHandler))
+	handle(http.MethodPost, "/v1/lists", app.requireOrgMember(app.createListHandler))
+	handle(http.MethodDelete, "/v1/lists/:id", app.requireOrgMember(app.deleteListHandler))
+
+	handle(http.MethodPost, "/v1/users", app.registerUserHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+
+	handle(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	handle(http.MethodPut, "/v1/users/me/phone", app.requireAuthenticatedUser(app.updatePhoneHandler))
+	handle(http.MethodPost, "/v1/users/me/phone/verify", app.requireAuthenticatedUser(app.verifyPhoneHandler))
+	handle(http.MethodPut, "/v1/users/me/reminders", app.requireAuthenticatedUser(app.updateRemindersHandler))
+	handle(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	handle(http.MethodDelete, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.deleteOtherSessionsHandler))
+	handle(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.deleteSessionHandler))
+	handle(http.MethodPost, "/v1/users/me/calendar-feed", app.requireOrgMember(app.createCalendarFeedHandler))
+	handle(http.MethodDelete, "/v1/users/me/calendar-feed", app.requireOrgMember(app.deleteCalendarFeedHandler))
+	handle(http.MethodPost, "/v1/users/me/caldav-password", app.requireOrgMember(app.createDAVPasswordHandler))
+	handle(http.MethodDelete, "/v1/users/me/caldav-password", app.requireOrgMember(app.deleteDAVPasswordHandler))
+
+	// CalDAV clients sync lists as calendars. They log in with an email
+	// address and a CalDAV app password
+	handle(http.MethodGet, davWellKnown, app.wellKnownCalDAVHandler)
+	handle(methodPropfind, davWellKnown, app.wellKnownCalDAVHandler)
+	for _, path := range []string{davRoot, davCalendars, davCalendars + ":list/", davCalendars + ":list/:object"} {
+		handle(http.MethodOptions, path, app.davOptionsHandler)
+	}
+	handle(methodPropfind, davRoot, app.requireDAVUser(app.propfindPrincipalHandler))
+	handle(methodPropfind, davCalendars, app.requireDAVUser(app.propfindCalendarHomeHandler))
+	handle(methodPropfind, davCalendars+":list/", app.requireDAVUser(app.propfindCalendarHandler))
+	handle(methodReport, davCalendars+":list/", app.requireDAVUser(app.reportCalendarHandler))
+	handle(methodPropfind, davCalendars+":list/:object", app.requireDAVUser(app.propfindCalendarObjectHandler))
+	handle(http.MethodGet, davCalendars+":list/:object", app.requireDAVUser(app.getCalendarObjectHandler))
+	handle(http.MethodPut, davCalendars+":list/:object", app.requireDAVUser(app.putCalendarObjectHandler))
+	handle(http.MethodDelete, davCalendars+":list/:object", app.requireDAVUser(app.deleteCalendarObjectHandler))
+
+	handle(http.MethodGet, "/v1/orgs", app.requireAuthenticatedUser(app.listOrganizationsHandler))
+	handle(http.MethodPost, "/v1/orgs", app.requireAuthenticatedUser(app.createOrganizationHandler))
+	handle(http.MethodGet, "/v1/orgs/:id/members", app.requireAuthenticatedUser(app.listOrganizationMembersHandler))
+	handle(http.MethodPost, "/v1/orgs/:id/invitations", app.requireAuthenticatedUser(app.createInvitationHandler))
+	handle(http.MethodPost, "/v1/invitations/accept", app.requireAuthenticatedUser(app.acceptInvitationHandler))
+
+	app.openapi = newAPIDocument(registered)
+
+	return app.requestID(app.recoverPanic(app.logAccess(app.recordMetrics(app.compress(app.enableCORS(app.rateLimitIP(app.authenticate(app.rateLimit(app.validateRequests(router))))))))))
+}
+
+// The byIDParam() method returns a handler that sends requests whose :id is
+// one of the static names to that name's handler, and all others to next
+func (app *application) byIDParam(next http.HandlerFunc, static map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := static[httprouter.ParamsFromContext(r.Context()).ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
 }
@@ -0,0 +1,113 @@
+// Filename: cmd/api/server.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method runs the HTTP server until it receives SIGINT or SIGTERM.
+// It then stops accepting requests, lets in-flight requests finish and waits
+// for the background goroutines before returning
+func (app *application) serve() error {
+	// create our http server
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		// Errors of the server itself go to our JSON log too
+		ErrorLog: log.New(app.logger, "", 0),
+	}
+
+	// The admin listener is only for operators, so it runs on its own address
+	var adminSrv *http.Server
+	if app.config.adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         app.config.adminAddr,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			ErrorLog:     log.New(app.logger, "", 0),
+		}
+		go func() {
+			app.logger.PrintInfo("starting admin server", map[string]string{"addr": adminSrv.Addr})
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": adminSrv.Addr})
+			}
+		}()
+	}
+
+	// Receives the result of the shutdown
+	shutdownError := make(chan error)
+	go func() {
+		// Wait for a signal
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+		app.logger.PrintInfo("shutting down server", map[string]string{"signal": sig.String()})
+
+		// Stop the periodic jobs, so they do not start new work while the
+		// server drains
+		app.stopJobs()
+		// Give in-flight requests and background work the configured deadline
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+		// Metrics stay up while the last requests drain
+		if adminSrv != nil {
+			err = adminSrv.Shutdown(ctx)
+			if err != nil {
+				shutdownError <- err
+				return
+			}
+		}
+		app.logger.PrintInfo("completing background tasks", map[string]string{"addr": srv.Addr})
+		done := make(chan struct{})
+		go func() {
+			// A job can still start background work until it has returned
+			app.jobs.Wait()
+			app.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			shutdownError <- errors.New("background tasks did not finish before the shutdown deadline")
+			return
+		}
+		// Keep the session usage that has not been written out yet
+		shutdownError <- app.models.Sessions.Touch(app.sessions.drain())
+	}()
+
+	// Start our server
+	app.logger.PrintInfo("starting server", map[string]string{"addr": srv.Addr, "env": app.config.env})
+	// ListenAndServe() returns http.ErrServerClosed as soon as Shutdown() is
+	// called, that is expected
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("stopped server", map[string]string{"addr": srv.Addr})
+	return nil
+}
@@ -0,0 +1,159 @@
+// Filename: cmd/api/sessions.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"todo.jamesfaber.net/internal/data"
+)
+
+// The sessionTracker collects session usage in memory so that an
+// authenticated request does not cost a database write. It also remembers
+// sessions revoked on this instance until their access tokens have expired
+type sessionTracker struct {
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+	revoked  map[string]time.Time
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		lastUsed: make(map[string]time.Time),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// The touch() method notes that a session was just used
+func (t *sessionTracker) touch(id string) {
+	t.mu.Lock()
+	t.lastUsed[id] = time.Now()
+	t.mu.Unlock()
+}
+
+// The revoke() method rejects the session's access tokens until forgetAt
+func (t *sessionTracker) revoke(id string, forgetAt time.Time) {
+	t.mu.Lock()
+	t.revoked[id] = forgetAt
+	delete(t.lastUsed, id)
+	t.mu.Unlock()
+}
+
+// The lastUsedAt() method returns usage that has not been flushed yet
+func (t *sessionTracker) lastUsedAt(id string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lastUsed, found := t.lastUsed[id]
+	return lastUsed, found
+}
+
+// The isRevoked() method checks if a session was revoked on this instance
+func (t *sessionTracker) isRevoked(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, found := t.revoked[id]
+	return found
+}
+
+// The drain() method hands over the collected usage and starts a new batch.
+// It also forgets revocations that no longer matter
+func (t *sessionTracker) drain() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	batch := t.lastUsed
+	t.lastUsed = make(map[string]time.Time)
+	now := time.Now()
+	for id, forgetAt := range t.revoked {
+		if now.After(forgetAt) {
+			delete(t.revoked, id)
+		}
+	}
+	return batch
+}
+
+// The flushSessionUsage() method writes the collected session usage to the
+// database once per interval until ctx is done. The usage of the requests
+// that finish during shutdown is written out by serve()
+func (app *application) flushSessionUsage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.models.Sessions.Touch(app.sessions.drain())
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
+
+// listSessionsHandler for the "GET /v1/users/me/sessions" endpoint
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Flag the session making the request
+	current := app.contextGetSession(r)
+	for _, session := range sessions {
+		session.Current = session.ID == current
+		// Usage that has not been flushed yet is more recent than the database
+		if lastUsed, ok := app.sessions.lastUsedAt(session.ID); ok && lastUsed.After(session.LastUsedAt) {
+			session.LastUsedAt = lastUsed
+		}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler for the "DELETE /v1/users/me/sessions/:id" endpoint
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	err := app.models.Sessions.Delete(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.sessions.revoke(id, time.Now().Add(app.config.jwt.accessTTL))
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOtherSessionsHandler for the "DELETE /v1/users/me/sessions" endpoint.
+// Every session except the one making the request is revoked
+func (app *application) deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	ids, err := app.models.Sessions.DeleteAllForUserExcept(user.ID, app.contextGetSession(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	forgetAt := time.Now().Add(app.config.jwt.accessTTL)
+	for _, id := range ids {
+		app.sessions.revoke(id, forgetAt)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"revoked": len(ids)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,32 +3,129 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"time"
 
 	"todo.jamesfaber.net/internal/data"
 	"todo.jamesfaber.net/internal/validator"
 )
 
+// A todoItem is a todo in a listing together with its entity tag
+type todoItem struct {
+	*data.Todo
+	ETag string `json:"etag"`
+}
+
+// The readProjection() method reads the ?fields= and ?include= parameters
+func (app *application) readProjection(qs url.Values, v *validator.Validator) data.Projection {
+	p := data.Projection{
+		Fields:  app.readCSV(qs, "fields", nil),
+		Include: app.readCSV(qs, "include", nil),
+	}
+	data.ValidateProjection(v, p)
+	return p
+}
+
+// The message for a parent_id that names no todo of the organization, or one
+// the todo is a parent of
+const parentIDError = "must be a todo of this organization that is not one of its subtasks"
+
+// The todoResponse() function returns a todo with its entity tag. When the
+// client picked fields only those, the included relations and the entity tag
+// are kept
+func todoResponse(todo *data.Todo, p data.Projection) (interface{}, error) {
+	item := todoItem{Todo: todo, ETag: todo.ETag()}
+	if len(p.Fields) == 0 {
+		return item, nil
+	}
+	js, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return nil, err
+	}
+	// Fields that are empty are left out of the todo but still sent as null
+	picked := make(map[string]json.RawMessage, len(p.Fields)+len(p.Include)+1)
+	for _, key := range append(p.Fields, p.Include...) {
+		value, ok := all[key]
+		if !ok {
+			value = json.RawMessage("null")
+		}
+		picked[key] = value
+	}
+	// Clients need it for conditional updates whatever fields they picked
+	picked["etag"] = all["etag"]
+	return picked, nil
+}
+
+// The projectedETag() function returns the entity tag of a response that has
+// picked fields or included relations. An included list can change while the
+// todo does not, so the tag is made from the response itself. It is weak, as
+// it does not name a version of the todo that If-Match could check
+func projectedETag(resp interface{}) (string, error) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(js)
+	return fmt.Sprintf(`W/"%x"`, sum[:16]), nil
+}
+
 // createTodoInfoHandler for the "POST" /v1/todoInfo" endpoint
 func (app *application) createTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	todo := app.createTodo(w, r)
+	if todo == nil {
+		return
+	}
+	// Create a location header for the newly created resource/Todo object
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/todoInfo/%d", todo.ID))
+	headers.Set("ETag", todo.ETag())
+	// Write the JSON response with 201 - created status code with the body
+	// being the actual todo data and the header being the headers map
+	err := app.writeJSON(w, r, http.StatusCreated, envelope{"todo": todo}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTodo() method creates a todo from the request body in the
+// organization of the request. It sends the error response and returns nil
+// if the todo could not be created. Both API versions use it
+func (app *application) createTodo(w http.ResponseWriter, r *http.Request) *data.Todo {
 	// Our Target decode destination
 	var input struct {
-		Name string `json:"name"`
-		Task string `json:"task"`
+		ListID   *int64     `json:"list_id"`
+		ParentID *int64     `json:"parent_id"`
+		Name     string     `json:"name"`
+		Task     string     `json:"task"`
+		Due      *time.Time `json:"due"`
 	}
 	// Initialize a new json.Decoder instance
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
-		return
+		return nil
 	}
 
 	//Copy the values from the input struct to a new todo struct
+	user := app.contextGetUser(r)
 	todo := &data.Todo{
-		Name: input.Name,
-		Task: input.Task,
+		CreatedBy: &user.ID,
+		ListID:    input.ListID,
+		ParentID:  input.ParentID,
+		Name:      input.Name,
+		Task:      input.Task,
+		Due:       input.Due,
 	}
 	// initialize a new Validator instance
 	v := validator.New()
@@ -36,24 +133,33 @@ func (app *application) createTodoInfoHandler(w http.ResponseWriter, r *http.Req
 	//Check the map to determine if there were any validation errors
 	if data.ValidateTodo(v, todo); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
-		return
+		return nil
 	}
 
-	// Create a Todo Object
-	err = app.models.Todos.Insert(todo)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	// Create a Todo Object in the organization of the request. Under an
+	// Idempotency-Key it is written with the stored response, and in a
+	// savepoint so a failed insert leaves room to store the error
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	if claim := app.contextGetIdempotencyClaim(r); claim != nil {
+		todos = claim.Todos(todos)
 	}
-
-	// Create a location header for the newly created resource/Todo object
-	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/todoInfo/%d", todo.ID))
-	// Write the JSON response with 201 - created status code with the body
-	// being the actual todo data and the header being the headers map
-	err = app.writeJSON(w, http.StatusCreated, envelope{"todo": todo}, headers)
+	err = todos.Savepoint(func() error {
+		return todos.Insert(todo)
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrInvalidList):
+			v.AddError("list_id", "must be a list of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvalidParent):
+			v.AddError("parent_id", parentIDError)
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
 	}
+	return todo
 }
 
 // showTodoInfoHandlerfor the "GET" /v1/todoinfo/:id" endpoint
@@ -63,8 +169,15 @@ func (app *application) showTodoInfoHandler(w http.ResponseWriter, r *http.Reque
 		app.notFoundResponse(w, r)
 		return
 	}
+	// The client can pick the fields and relations it wants
+	v := validator.New()
+	projection := app.readProjection(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 	// Fetch the specific todo task
-	todo, err := app.models.Todos.Get(id)
+	todo, err := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).WithProjection(projection).Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -74,23 +187,60 @@ func (app *application) showTodoInfoHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	// Write the response by Get()
-	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	resp, err := todoResponse(todo, projection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Let the client revalidate its cached copy. A projection is a different
+	// representation, so it gets a tag of its own
+	etag := todo.ETag()
+	if len(projection.Fields) > 0 || len(projection.Include) > 0 {
+		etag, err = projectedETag(resp)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"todo": resp}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	todo := app.updateTodo(w, r)
+	if todo == nil {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", todo.ETag())
+	err := app.writeJSON(w, r, http.StatusCreated, envelope{"todo": todo}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateTodo() method changes the todo with the :id parameter using the
+// request body. It sends the error response and returns nil if the todo could
+// not be updated. Both API versions use it
+func (app *application) updateTodo(w http.ResponseWriter, r *http.Request) *data.Todo {
 	// This method does a partial replacement
 	// Get the id for the todo task that needs updating
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
-		return
+		return nil
 	}
+	// Only todos of the request's organization can be updated
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
 	// Fetch the original record from the database
-	todo, err := app.models.Todos.Get(id)
+	todo, err := todos.Get(id)
 	// Error handling
 	if err != nil {
 		switch {
@@ -99,29 +249,77 @@ func (app *application) updateTodoInfoHandler(w http.ResponseWriter, r *http.Req
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-		return
-	}
-	// Create an input struct to hold data read in from the client
-	// We update the input struct to use pointers because pointers have a
-	// default value of nil false
-	// if a field remains nil then we know that the client did not update it
-	var input struct {
-		Name *string `json:"name"`
-		Task *string `json:"Task"`
+		return nil
 	}
-
-	//Initalize a new json.Decoder instance
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+	// The client can make the update depend on the version it edited
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, todo.ETag(), false) {
+		app.preconditionFailedResponse(w, r)
+		return nil
 	}
-	// Check for updates
-	if input.Name != nil {
-		todo.Name = *input.Name
+	// The body is a plain JSON object of the fields to change, an RFC 7396
+	// merge patch or an RFC 6902 JSON Patch depending on its Content-Type
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return nil
+		}
 	}
-	if input.Task != nil {
-		todo.Task = *input.Task
+	switch mediaType {
+	case "application/json":
+		// Create an input struct to hold data read in from the client
+		// We update the input struct to use pointers because pointers have a
+		// default value of nil false
+		// if a field remains nil then we know that the client did not update it
+		var input struct {
+			ListID   *int64     `json:"list_id"`
+			ParentID *int64     `json:"parent_id"`
+			Name     *string    `json:"name"`
+			Task     *string    `json:"task"`
+			Due      *time.Time `json:"due"`
+		}
+
+		//Initalize a new json.Decoder instance
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return nil
+		}
+		// Check for updates
+		if input.ListID != nil {
+			todo.ListID = input.ListID
+		}
+		if input.ParentID != nil {
+			todo.ParentID = input.ParentID
+		}
+		if input.Name != nil {
+			todo.Name = *input.Name
+		}
+		if input.Task != nil {
+			todo.Task = *input.Task
+		}
+		if input.Due != nil {
+			todo.Due = input.Due
+		}
+	case mergePatchMediaType, jsonPatchMediaType:
+		err = app.applyTodoPatch(w, r, mediaType, todo)
+		if err != nil {
+			var patchErr *patchError
+			switch {
+			case errors.Is(err, errPatchTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			case errors.As(err, &patchErr):
+				app.failedValidationResponse(w, r, map[string]string{"patch": patchErr.Error()})
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return nil
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r, mediaType)
+		return nil
 	}
 
 	// Perform Validation on the updated todo task. If validation fails then
@@ -132,36 +330,85 @@ func (app *application) updateTodoInfoHandler(w http.ResponseWriter, r *http.Req
 	//Check the map to determine if there were any validation errors
 	if data.ValidateTodo(v, todo); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
-		return
+		return nil
 	}
 	// Pass the update todo record to the Update() method
-	err = app.models.Todos.Update(todo)
+	err = todos.Update(todo)
 	if err != nil {
 		switch {
+		// The version matched the If-Match header but changed since then
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrInvalidList):
+			v.AddError("list_id", "must be a list of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvalidParent):
+			v.AddError("parent_id", parentIDError)
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return nil
+	}
+	return todo
+}
+
+// The deleteTodoInfoHandler() allows the user to delete a todo info from the databse by using the ID
+func (app *application) deleteTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.deleteTodo(w, r) {
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"todo": todo}, nil)
+	// Return 200 Status OK to the client with a success message
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"message": "todo info successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-
 }
 
-// The deleteTodoInfoHandler() allows the user to delete a todo info from the databse by using the ID
-func (app *application) deleteTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+// The deleteTodo() method deletes the todo with the :id parameter. It sends
+// the error response and returns false if the todo could not be deleted.
+// Both API versions use it
+func (app *application) deleteTodo(w http.ResponseWriter, r *http.Request) bool {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
-		return
+		return false
+	}
+	todos := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID)
+	// With If-Match the todo is only deleted if it is still at the version
+	// the client has seen
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		todo, err := todos.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return false
+		}
+		if !etagMatches(ifMatch, todo.ETag(), false) {
+			app.preconditionFailedResponse(w, r)
+			return false
+		}
+		err = todos.DeleteVersion(todo.ID, todo.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.preconditionFailedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return false
+		}
+		return true
 	}
 	// Delete the todo tasks from the database. Send a 404 Not Found status code to the
 	// client if there is no matching record
-	err = app.models.Todos.Delete(id)
+	err = todos.Delete(id)
 	// Error handling
 	if err != nil {
 		switch {
@@ -170,28 +417,22 @@ func (app *application) deleteTodoInfoHandler(w http.ResponseWriter, r *http.Req
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-		return
-	}
-	// Return 200 Status OK to the client with a success message
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "todo info successfully deleted"}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return false
 	}
+	return true
 }
 
-// The listTodoInfoHandler() allows the client to see a listing of todo tasks
-// based on a set criteria
-func (app *application) listTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
-	// Create an input struct to hold our query parameter
-	var input struct {
-		Name string
-		Task string
-		data.Filters
-	}
-	// Initialize a validator
-	v := validator.New()
-	// Get the URL values map
-	qs := r.URL.Query()
+// A todoSearch holds the query parameters of a listing of todo tasks
+type todoSearch struct {
+	Name string
+	Task string
+	data.Filters
+}
+
+// The readTodoSearch() method reads the search, sort and paging parameters
+// of a listing of todo tasks. Both API versions use it
+func (app *application) readTodoSearch(qs url.Values, v *validator.Validator) todoSearch {
+	var input todoSearch
 	// use the helper methods to extract values
 	input.Name = app.readString(qs, "name", "")
 	input.Task = app.readString(qs, "task", "")
@@ -202,19 +443,67 @@ func (app *application) listTodoInfoHandler(w http.ResponseWriter, r *http.Reque
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	// Specify the allowed sort values
 	input.Filters.SortList = []string{"id", "name", "task", "-id", "-name", "-task"}
+	// Continue from the next_cursor of an earlier page
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	// Counting every match is the slow part of a listing, so clients can
+	// settle for an estimate or no total at all
+	input.Filters.Total = app.readString(qs, "total", data.TotalExact)
+	return input
+}
+
+// The listTodoInfoHandler() allows the client to see a listing of todo tasks
+// based on a set criteria
+func (app *application) listTodoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	// Initialize a validator
+	v := validator.New()
+	// Get the URL values map
+	qs := r.URL.Query()
+	input := app.readTodoSearch(qs, v)
+	// The client can pick the fields and relations it wants
+	projection := app.readProjection(qs, v)
 	// Check for validation errors
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	// Get a listing of all todo tasks
-	todos, metadata, err := app.models.Todos.GetAll(input.Name, input.Task, input.Filters)
+	todos, metadata, err := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).WithProjection(projection).GetAll(input.Name, input.Task, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	// Pages can also be had as CSV or NDJSON. The way to the next page is
+	// then given in a Link header
+	if mediaType := app.negotiate(w, r, "application/json", csvMediaType, ndjsonMediaType); mediaType != "application/json" {
+		headers := make(http.Header)
+		if metadata.NextCursor != "" {
+			next := r.URL.Query()
+			next.Del("page")
+			next.Set("cursor", metadata.NextCursor)
+			headers.Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+		}
+		app.streamTodos(w, r, mediaType, headers, func(fn func(*data.Todo) error) error {
+			for _, todo := range todos {
+				if err := fn(todo); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+	// Give every item its entity tag so the client can make later updates
+	// conditional without fetching each todo first
+	items := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		items[i], err = todoResponse(todo, projection)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	// Send a JSON response containing all the todo tasks
-	err = app.writeJSON(w, http.StatusOK, envelope{"todos": todos, "metadata": metadata}, nil)
+	err = app.writeJSONList(w, r, http.StatusOK, "todos", items, envelope{"metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
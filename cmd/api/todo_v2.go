@@ -0,0 +1,183 @@
+// Filename: cmd/api/todo_v2.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The v1 todoInfo endpoints were deprecated when /v2/todos came out and will
+// be removed after the sunset
+var (
+	v1Deprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	v1Sunset      = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// A todoV2 is a todo as the v2 API shows it. Every field is always sent, as
+// null when it is not set, and times are RFC 3339 in UTC
+type todoV2 struct {
+	ID          int64   `json:"id"`
+	ListID      *int64  `json:"list_id"`
+	ParentID    *int64  `json:"parent_id"`
+	Name        string  `json:"name"`
+	Task        string  `json:"task"`
+	Due         *string `json:"due"`
+	Priority    *string `json:"priority"`
+	CompletedAt *string `json:"completed_at"`
+	Version     int32   `json:"version"`
+	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at"`
+	ETag        string  `json:"etag"`
+}
+
+// The rfc3339() function formats a time the way the v2 API sends it
+func rfc3339(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// The newTodoV2() function returns the v2 representation of a todo
+func newTodoV2(todo *data.Todo) todoV2 {
+	item := todoV2{
+		ID:        todo.ID,
+		ListID:    todo.ListID,
+		ParentID:  todo.ParentID,
+		Name:      todo.Name,
+		Task:      todo.Task,
+		Version:   todo.Version,
+		CreatedAt: rfc3339(todo.CreatedAt),
+		UpdatedAt: rfc3339(todo.UpdatedAt),
+		ETag:      todo.ETag(),
+	}
+	if todo.Due != nil {
+		due := rfc3339(*todo.Due)
+		item.Due = &due
+	}
+	if todo.Priority != "" {
+		priority := todo.Priority
+		item.Priority = &priority
+	}
+	if todo.CompletedAt != nil {
+		completedAt := rfc3339(*todo.CompletedAt)
+		item.CompletedAt = &completedAt
+	}
+	return item
+}
+
+// listTodosV2Handler for the "GET /v2/todos" endpoint. It takes the search,
+// sort and paging parameters of v1 and links to the next page
+func (app *application) listTodosV2Handler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	input := app.readTodoSearch(qs, v)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	todos, metadata, err := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).GetAll(input.Name, input.Task, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	items := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		items[i] = newTodoV2(todo)
+	}
+	headers := make(http.Header)
+	if metadata.NextCursor != "" {
+		next := r.URL.Query()
+		next.Del("page")
+		next.Set("cursor", metadata.NextCursor)
+		headers.Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	err = app.writeJSONList(w, r, http.StatusOK, "todos", items, envelope{"metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTodoV2Handler for the "POST /v2/todos" endpoint
+func (app *application) createTodoV2Handler(w http.ResponseWriter, r *http.Request) {
+	todo := app.createTodo(w, r)
+	if todo == nil {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v2/todos/%d", todo.ID))
+	headers.Set("ETag", todo.ETag())
+	err := app.writeJSON(w, r, http.StatusCreated, envelope{"todo": newTodoV2(todo)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showTodoV2Handler for the "GET /v2/todos/:id" endpoint
+func (app *application) showTodoV2Handler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	todo, err := app.models.Todos.ForOrg(app.contextGetMembership(r).OrgID).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("ETag", todo.ETag())
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, todo.ETag(), true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"todo": newTodoV2(todo)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTodoV2Handler for the "PATCH /v2/todos/:id" endpoint. It takes the
+// same bodies as v1 but answers 200 rather than 201
+func (app *application) updateTodoV2Handler(w http.ResponseWriter, r *http.Request) {
+	todo := app.updateTodo(w, r)
+	if todo == nil {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", todo.ETag())
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"todo": newTodoV2(todo)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTodoV2Handler for the "DELETE /v2/todos/:id" endpoint. There is
+// nothing left to send, so it answers 204
+func (app *application) deleteTodoV2Handler(w http.ResponseWriter, r *http.Request) {
+	if app.deleteTodo(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// The deprecated() method returns a handler that marks the responses of a v1
+// endpoint as deprecated (RFC 9745) with a sunset date (RFC 8594), and links
+// to its v2 successor. The successor has the path of the request with the v1
+// prefix replaced
+func (app *application) deprecated(v1Prefix string, v2Prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", v1Deprecation.Unix()))
+		w.Header().Set("Sunset", v1Sunset.Format(http.TimeFormat))
+		successor := v2Prefix + strings.TrimPrefix(r.URL.Path, v1Prefix)
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		next(w, r)
+	}
+}
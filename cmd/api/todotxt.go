@@ -0,0 +1,240 @@
+// Filename: cmd/api/todotxt.go
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/todotxt"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The relations a todo.txt line needs besides the todo itself
+var todoTxtProjection = data.Projection{Include: []string{"list", "tags"}}
+
+// The todoTxtTask() function maps a todo onto a todo.txt task. The list
+// becomes the first +project and the tags become @contexts, except for tags
+// starting with + which are the projects that did not match a list
+func todoTxtTask(todo *data.Todo) todotxt.Task {
+	task := todotxt.Task{
+		Completed:      todo.CompletedAt != nil,
+		Priority:       todo.Priority,
+		CompletionDate: todo.CompletedAt,
+		Description:    todo.Task,
+	}
+	// todo.txt only has a description, so a name that differs from the task
+	// goes in front of it. A name that would not be read back the same, say
+	// one with ": " or a +project in it, goes in a name: extension instead
+	if todo.Name != todo.Task {
+		task.Description = todo.Name + ": " + todo.Task
+	}
+	parsed, err := todotxt.Parse(task.Description)
+	if name, text := splitTodoTxtDescription(parsed.Description); err != nil || name != todo.Name || text != todo.Task {
+		task.Description = todo.Task
+		task.Extensions = append(task.Extensions, todotxt.Extension{Key: "name", Value: url.QueryEscape(todo.Name)})
+	}
+	if !todo.CreatedAt.IsZero() {
+		task.CreationDate = &todo.CreatedAt
+	}
+	if todo.List != nil {
+		task.Projects = append(task.Projects, strings.ReplaceAll(todo.List.Name, " ", "_"))
+	}
+	if todo.Tags != nil {
+		for _, tag := range *todo.Tags {
+			if project := strings.TrimPrefix(tag, "+"); project != tag {
+				task.Projects = append(task.Projects, project)
+			} else {
+				task.Contexts = append(task.Contexts, tag)
+			}
+		}
+	}
+	if todo.Due != nil {
+		task.Extensions = append(task.Extensions, todotxt.Extension{Key: "due", Value: todo.Due.Format(todotxt.DateLayout)})
+	}
+	// Completed tasks keep their priority as an extension
+	if task.Completed && task.Priority != "" {
+		task.Extensions = append(task.Extensions, todotxt.Extension{Key: "pri", Value: task.Priority})
+		task.Priority = ""
+	}
+	for _, ext := range todo.Extensions {
+		key, value, _ := strings.Cut(ext, ":")
+		task.Extensions = append(task.Extensions, todotxt.Extension{Key: key, Value: value})
+	}
+	return task
+}
+
+// The splitTodoTxtDescription() function splits a todo.txt description into
+// the name and task todoTxtTask() joined. A description without a name is both
+func splitTodoTxtDescription(description string) (name string, task string) {
+	if name, task, ok := strings.Cut(description, ": "); ok {
+		return name, task
+	}
+	return description, description
+}
+
+// The todoFromTodoTxt() function maps a todo.txt task onto a todo and its
+// tags. A +project that names a list files the todo under it
+func todoFromTodoTxt(task todotxt.Task, lists map[string]int64) (*data.Todo, []string, map[string]string) {
+	name, text := splitTodoTxtDescription(task.Description)
+	todo := &data.Todo{
+		Name:     name,
+		Task:     text,
+		Priority: task.Priority,
+	}
+	problems := make(map[string]string)
+	if value, ok := task.Extension("name"); ok {
+		name, err := url.QueryUnescape(value)
+		if err != nil {
+			problems["name"] = "must be URL encoded"
+		}
+		todo.Name, todo.Task = name, task.Description
+	}
+	if task.CreationDate != nil {
+		todo.CreatedAt = *task.CreationDate
+	}
+	if task.Completed {
+		completedAt := time.Now().UTC().Truncate(24 * time.Hour)
+		if task.CompletionDate != nil {
+			completedAt = *task.CompletionDate
+		}
+		todo.CompletedAt = &completedAt
+	}
+	tags := []string{}
+	for _, project := range task.Projects {
+		if id, ok := lists[project]; ok && todo.ListID == nil {
+			todo.ListID = &id
+			continue
+		}
+		tags = append(tags, "+"+project)
+	}
+	tags = append(tags, task.Contexts...)
+	for _, ext := range task.Extensions {
+		switch {
+		case ext.Key == "due" && todo.Due == nil:
+			due, err := time.Parse(todotxt.DateLayout, ext.Value)
+			if err != nil {
+				problems["due"] = "must be a YYYY-MM-DD date"
+			}
+			todo.Due = &due
+		case ext.Key == "pri" && task.Completed && todo.Priority == "":
+			todo.Priority = ext.Value
+		case ext.Key == "name":
+		default:
+			// Extensions we do not know are kept so they can be written back
+			todo.Extensions = append(todo.Extensions, ext.Key+":"+ext.Value)
+		}
+	}
+	return todo, tags, problems
+}
+
+// A todoTxtWriter writes every todo as a todo.txt line
+type todoTxtWriter struct {
+	w io.Writer
+}
+
+func (tw *todoTxtWriter) Write(todo *data.Todo) error {
+	_, err := io.WriteString(tw.w, todoTxtTask(todo).String()+"\n")
+	return err
+}
+
+func (tw *todoTxtWriter) Flush() error {
+	return nil
+}
+
+// A todoTxtImportReader reads one task per line of a todo.txt file
+type todoTxtImportReader struct {
+	s     *bufio.Scanner
+	line  int
+	lists map[string]int64
+}
+
+func (tr *todoTxtImportReader) Next() (importRow, error) {
+	for tr.s.Scan() {
+		tr.line++
+		// Blank lines are allowed between tasks
+		if strings.TrimSpace(tr.s.Text()) == "" {
+			continue
+		}
+		task, err := todotxt.Parse(tr.s.Text())
+		if err != nil {
+			return importRow{line: tr.line, errors: map[string]string{"todotxt": err.Error()}}, nil
+		}
+		todo, tags, problems := todoFromTodoTxt(task, tr.lists)
+		return importRow{line: tr.line, todo: todo, tags: tags, errors: problems}, nil
+	}
+	if err := tr.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+// The insertTodoTxtRows() function writes a batch of todo.txt todos with
+// COPY, followed by their tags
+func insertTodoTxtRows(tx data.TodoModel, rows []importRow) error {
+	err := copyImportRows(tx, rows)
+	if err != nil {
+		return err
+	}
+	// CopyIn() has set the ids the tags belong to
+	tags := make(map[int64][]string)
+	for _, row := range rows {
+		if len(row.tags) > 0 {
+			tags[row.todo.ID] = row.tags
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tx.CopyTags(tags)
+}
+
+// exportTodoTxtHandler for the "GET /v1/todoInfo/todotxt" endpoint. It writes
+// every todo that matches the search as a todo.txt file
+func (app *application) exportTodoTxtHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportTodos(w, r, todoTxtMediaType, "todo.txt", todoTxtProjection)
+}
+
+// importTodoTxtHandler for the "POST /v1/todoInfo/todotxt" endpoint. It adds
+// the tasks of a todo.txt file like importTodoInfoHandler adds CSV rows
+func (app *application) importTodoTxtHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ctx, cancel := app.extendImportDeadlines(w, r)
+	defer cancel()
+
+	lists, err := app.models.Lists.ForOrg(app.contextGetMembership(r).OrgID).GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Projects are matched to lists the way lists are written as projects
+	listIDs := make(map[string]int64, len(lists))
+	for _, list := range lists {
+		listIDs[strings.ReplaceAll(list.Name, " ", "_")] = list.ID
+	}
+
+	file := app.spoolImport(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+	open := func() (importReader, error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(file)
+		s.Buffer(make([]byte, 64*1024), 1_048_576)
+		return &todoTxtImportReader{s: s, lists: listIDs}, nil
+	}
+	app.runImport(ctx, w, r, open, dryRun, lists, insertTodoTxtRows)
+}
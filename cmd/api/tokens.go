@@ -0,0 +1,139 @@
+// Filename: cmd/api/tokens.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/jwt"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The newAccessToken() method signs a short lived access token for a user
+// session
+func (app *application) newAccessToken(userID int64, session string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(app.config.jwt.accessTTL)
+	token, err := app.jwtKeys.Sign(jwt.Claims{
+		Subject:   strconv.FormatInt(userID, 10),
+		Issuer:    app.config.jwt.issuer,
+		Audience:  app.config.jwt.audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Expiry:    expiry.Unix(),
+		Session:   session,
+	})
+	return token, expiry, err
+}
+
+// The writeTokenPair() method sends an access token together with the refresh
+// token that can be used to get the next one
+func (app *application) writeTokenPair(w http.ResponseWriter, r *http.Request, status int, refreshToken *data.RefreshToken) {
+	accessToken, expiry, err := app.newAccessToken(refreshToken.UserID, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{
+		"authentication_token": envelope{
+			"token":      accessToken,
+			"token_type": "Bearer",
+			"expiry":     expiry,
+		},
+		"refresh_token": refreshToken,
+	}
+	err = app.writeJSON(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAuthenticationTokenHandler for the "POST /v1/tokens/authentication" endpoint
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Look up the user and check the password
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	// Start a new session for this login
+	session, err := app.models.Sessions.New(user.ID, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	refreshToken, err := app.models.RefreshTokens.New(user.ID, session.ID, app.config.jwt.refreshTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeTokenPair(w, r, http.StatusCreated, refreshToken)
+}
+
+// refreshAuthenticationTokenHandler for the "POST /v1/tokens/refresh" endpoint
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateRefreshTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Swap the refresh token for the next one in its family
+	refreshToken, err := app.models.RefreshTokens.Rotate(input.RefreshToken, app.config.jwt.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logError(r, err)
+			// The access tokens of the session must stop working as well
+			app.sessions.revoke(refreshToken.Family, time.Now().Add(app.config.jwt.accessTTL))
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeTokenPair(w, r, http.StatusCreated, refreshToken)
+}
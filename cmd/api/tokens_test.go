@@ -0,0 +1,89 @@
+// Filename: cmd/api/tokens_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"todo.jamesfaber.net/internal/data"
+)
+
+// The tokenPair type is the body of a login or refresh response
+type tokenPair struct {
+	AuthenticationToken struct {
+		Token string `json:"token"`
+	} `json:"authentication_token"`
+	RefreshToken struct {
+		Token string `json:"token"`
+	} `json:"refresh_token"`
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	app := newTestApp(t)
+	user := &data.User{Name: "Token Tester", Email: "tokens@example.com"}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(user, &data.Organization{Name: user.Name}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(app.routes())
+	t.Cleanup(server.Close)
+
+	do := func(method string, path string, accessToken string, body string) (int, tokenPair) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var pair tokenPair
+		json.NewDecoder(res.Body).Decode(&pair)
+		return res.StatusCode, pair
+	}
+	refresh := func(token string) (int, tokenPair) {
+		return do(http.MethodPost, "/v1/tokens/refresh", "", `{"refresh_token":"`+token+`"}`)
+	}
+
+	status, first := do(http.MethodPost, "/v1/tokens/authentication", "", `{"email":"tokens@example.com","password":"pa55word1234"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("login: got status %d, want 201", status)
+	}
+	status, second := refresh(first.RefreshToken.Token)
+	if status != http.StatusCreated {
+		t.Fatalf("first refresh: got status %d, want 201", status)
+	}
+	if status, _ := do(http.MethodGet, "/v1/users/me", second.AuthenticationToken.Token, ""); status != http.StatusOK {
+		t.Fatalf("access token of the rotated pair: got status %d, want 200", status)
+	}
+
+	// The first refresh token was used already, so whoever sends it again
+	// may have stolen it. The whole family must stop working
+	if status, _ := refresh(first.RefreshToken.Token); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: got status %d, want 401", status)
+	}
+	if status, _ := refresh(second.RefreshToken.Token); status != http.StatusUnauthorized {
+		t.Errorf("newest refresh token of the family: got status %d, want 401", status)
+	}
+	for name, token := range map[string]string{"first": first.AuthenticationToken.Token, "second": second.AuthenticationToken.Token} {
+		if status, _ := do(http.MethodGet, "/v1/users/me", token, ""); status != http.StatusUnauthorized {
+			t.Errorf("%s access token of the family: got status %d, want 401", name, status)
+		}
+	}
+
+	// A new login starts a family of its own
+	if status, _ := do(http.MethodPost, "/v1/tokens/authentication", "", `{"email":"tokens@example.com","password":"pa55word1234"}`); status != http.StatusCreated {
+		t.Errorf("login after the reuse: got status %d, want 201", status)
+	}
+}
@@ -0,0 +1,186 @@
+// Filename: cmd/api/users.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"todo.jamesfaber.net/internal/data"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// registerUserHandler for the "POST /v1/users" endpoint
+func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Our Target decode destination
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	//Copy the values from the input struct to a new user struct
+	user := &data.User{
+		Name:  input.Name,
+		Email: input.Email,
+	}
+	// Hash the password
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// initialize a new Validator instance
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Every user starts out with an organization of their own
+	org := &data.Organization{Name: user.Name}
+	err = app.models.Users.Insert(user, org)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.duplicateEmailResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"user": user, "organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// How long a phone verification code stays valid
+const phoneCodeTTL = 10 * time.Minute
+
+// showCurrentUserHandler for the "GET /v1/users/me" endpoint
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePhoneHandler for the "PUT /v1/users/me/phone" endpoint. The number is
+// stored unverified and a one-time code is sent to it by SMS
+func (app *application) updatePhoneHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone string `json:"phone"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidatePhone(v, input.Phone); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	code, err := app.models.Phones.NewCode(app.contextGetUser(r).ID, input.Phone, phoneCodeTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTooManyPhoneCodes):
+			app.tooManyPhoneCodesResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.sms.Send(input.Phone, fmt.Sprintf("Your Todo verification code is %s", code))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"message": "a verification code has been sent to your phone"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyPhoneHandler for the "POST /v1/users/me/phone/verify" endpoint
+func (app *application) verifyPhoneHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidatePhoneCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Phones.Verify(app.contextGetUser(r).ID, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired verification code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrPhoneChanged):
+			v.AddError("code", "was sent to a phone number that has since changed")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "phone number successfully verified"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRemindersHandler for the "PUT /v1/users/me/reminders" endpoint
+func (app *application) updateRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SMS *bool `json:"sms"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.SMS != nil, "sms", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Phones.SetReminders(app.contextGetUser(r).ID, *input.SMS)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrPhoneNotVerified):
+			v.AddError("sms", "requires a verified phone number")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"reminders": envelope{"sms": *input.SMS}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
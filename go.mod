@@ -1,7 +1,11 @@
 module todo.jamesfaber.net
 
-go 1.19
+go 1.20
 
 require github.com/julienschmidt/httprouter v1.3.0
 
 require github.com/lib/pq v1.10.2
+
+require golang.org/x/crypto v0.14.0
+
+require golang.org/x/time v0.3.0
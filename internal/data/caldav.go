@@ -0,0 +1,239 @@
+// Filename: internal/data/caldav.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidSyncToken is returned for a sync token the list never had, or
+// one from before tombstones were pruned
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// ResourceName() returns the name of the todo in its CalDAV calendar. Todos
+// that were not created over CalDAV are named after their id
+func (t *Todo) ResourceName() string {
+	if t.DAVName != nil {
+		return *t.DAVName
+	}
+	return fmt.Sprintf("todo-%d.ics", t.ID)
+}
+
+// The todos of one list of an organization, or the ones in no list when the
+// list id is NULL. It takes the organization and list as $1 and $2
+const todoInList = `
+		WHERE org_id = $1 AND list_id IS NOT DISTINCT FROM $2`
+
+// GetByResourceName() returns the todo with a CalDAV resource name in a list
+func (m TodoModel) GetByResourceName(listID *int64, name string) (*Todo, error) {
+	defer m.observe("get_by_resource_name", time.Now())
+	var todo Todo
+	columns, dest, finish := m.projection.scan(&todo)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo %s
+		AND (dav_name = $3 OR (dav_name IS NULL AND 'todo-' || id || '.ics' = $3))
+	`, columns, todoInList)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.db().QueryRowContext(ctx, query, requireTenant(m.OrgID), listID, name).Scan(dest...)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &todo, nil
+}
+
+// GetByUID() returns a todo of the organization by the iCalendar UID it was
+// added with. If several todos share the UID the oldest is returned
+func (m TodoModel) GetByUID(uid string) (*Todo, error) {
+	defer m.observe("get_by_uid", time.Now())
+	var todo Todo
+	columns, dest, finish := m.projection.scan(&todo)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo
+		WHERE org_id = $1 AND ical_uid = $2
+		ORDER BY id
+		LIMIT 1
+	`, columns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.db().QueryRowContext(ctx, query, requireTenant(m.OrgID), uid).Scan(dest...)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &todo, nil
+}
+
+// SyncToken() returns the sync token of a list. Every change is marked with
+// the transaction that made it, and the token is the transaction id below
+// which all changes to the list have been seen. It only moves when the list
+// changes, and never past a transaction that is still running, since that one
+// may still commit a change with an older id
+func (m TodoModel) SyncToken(listID *int64) (int64, error) {
+	defer m.observe("sync_token", time.Now())
+	query := fmt.Sprintf(`
+		SELECT LEAST(
+			pg_snapshot_xmin(pg_current_snapshot())::text::bigint,
+			GREATEST(
+				(SELECT COALESCE(MAX(sync_xid), 0) FROM todo %[1]s),
+				(SELECT COALESCE(MAX(sync_xid), 0) FROM todo_tombstones %[1]s)) + 1)
+	`, todoInList)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	var token int64
+	err := m.db().QueryRowContext(ctx, query, requireTenant(m.OrgID), listID).Scan(&token)
+	return token, err
+}
+
+// SyncChanges holds what happened in a list since a sync token
+type SyncChanges struct {
+	Changed []*Todo
+	Removed []string
+	Token   int64
+}
+
+// Changes() returns the todos of a list that were added or changed since the
+// sync token, and the names of the ones that were removed. A token of 0 gets
+// every todo in the list. Changes past the new token are sent again with the
+// next sync, which clients take as nothing new
+func (m TodoModel) Changes(listID *int64, since int64) (*SyncChanges, error) {
+	defer m.observe("changes", time.Now())
+	token, err := m.SyncToken(listID)
+	if err != nil {
+		return nil, err
+	}
+	if since < 0 || since > token {
+		return nil, ErrInvalidSyncToken
+	}
+	changes := &SyncChanges{Changed: []*Todo{}, Removed: []string{}, Token: token}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	// Removals before the newest pruned tombstone can no longer be reported
+	if since > 0 {
+		var pruned int64
+		err := m.db().QueryRowContext(ctx, `
+			SELECT COALESCE((SELECT pruned_xid FROM todo_tombstone_horizons WHERE org_id = $1), 0)`,
+			requireTenant(m.OrgID)).Scan(&pruned)
+		if err != nil {
+			return nil, err
+		}
+		if since <= pruned {
+			return nil, ErrInvalidSyncToken
+		}
+	}
+
+	columns, _, _ := m.projection.scan(&Todo{})
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo %s
+		AND sync_xid >= $3
+		ORDER BY id
+	`, columns, todoInList)
+	rows, err := m.db().QueryContext(ctx, query, requireTenant(m.OrgID), listID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var todo Todo
+		_, dest, finish := m.projection.scan(&todo)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		changes.Changed = append(changes.Changed, &todo)
+		names[todo.ResourceName()] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// A fresh sync has nothing to remove
+	if since == 0 {
+		return changes, nil
+	}
+
+	query = fmt.Sprintf(`
+		SELECT DISTINCT name
+		FROM todo_tombstones %s
+		AND sync_xid >= $3
+	`, todoInList)
+	rows, err = m.db().QueryContext(ctx, query, requireTenant(m.OrgID), listID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		// A todo that left the list and came back has only changed
+		if !names[name] {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// PruneTombstones() deletes the tombstones that are older than retention in
+// every organization, and moves each organization's horizon up to the newest
+// one it pruned. It is not scoped to an organization
+func (m TodoModel) PruneTombstones(retention time.Duration) (int64, error) {
+	defer m.observe("prune_tombstones", time.Now())
+	query := `
+		WITH pruned AS (
+			DELETE FROM todo_tombstones
+			WHERE created_at < NOW() - make_interval(secs => $1)
+			RETURNING org_id, sync_xid
+		), horizons AS (
+			INSERT INTO todo_tombstone_horizons (org_id, pruned_xid)
+			SELECT org_id, MAX(sync_xid) FROM pruned GROUP BY org_id
+			ON CONFLICT (org_id) DO UPDATE
+			SET pruned_xid = GREATEST(todo_tombstone_horizons.pruned_xid, EXCLUDED.pruned_xid)
+		)
+		SELECT COUNT(*) FROM pruned
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	var count int64
+	err := m.DB.QueryRowContext(ctx, query, retention.Seconds()).Scan(&count)
+	return count, err
+}
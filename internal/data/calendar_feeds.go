@@ -0,0 +1,108 @@
+// Filename: internal/data/calendar_feeds.go
+
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// A CalendarFeed lets calendar apps, which cannot log in, read the todos of an
+// organization through a URL with a secret token in it
+type CalendarFeed struct {
+	Token     string    `json:"token"`
+	UserID    int64     `json:"-"`
+	OrgID     int64     `json:"org_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateCalendarFeedToken(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// Define a calendar feed model which wraps a sql.DB connection pool
+type CalendarFeedModel struct {
+	DB *sql.DB
+}
+
+// New() creates the feed of a user for an organization. An existing feed
+// gets a new token, so the old URL stops working
+func (m CalendarFeedModel) New(userID int64, orgID int64) (*CalendarFeed, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	feed := &CalendarFeed{Token: plaintext, UserID: userID, OrgID: orgID}
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+		INSERT INTO calendar_feeds (hash, user_id, org_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, org_id) DO UPDATE SET hash = EXCLUDED.hash, created_at = NOW()
+		RETURNING created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, hash[:], userID, orgID).Scan(&feed.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// GetForToken() returns the feed a token belongs to. The returned feed has
+// no Token since only its hash is stored
+func (m CalendarFeedModel) GetForToken(tokenPlaintext string) (*CalendarFeed, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		SELECT user_id, org_id, created_at
+		FROM calendar_feeds
+		WHERE hash = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	var feed CalendarFeed
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&feed.UserID, &feed.OrgID, &feed.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &feed, nil
+}
+
+// Delete() removes the feed of a user for an organization
+func (m CalendarFeedModel) Delete(userID int64, orgID int64) error {
+	query := `
+		DELETE FROM calendar_feeds
+		WHERE user_id = $1 AND org_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, orgID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
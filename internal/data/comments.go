@@ -0,0 +1,91 @@
+// Filename: internal/data/comments.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// A Comment is a note a member left on a todo
+type Comment struct {
+	ID        int64     `json:"id"`
+	TodoID    int64     `json:"todo_id"`
+	UserID    *int64    `json:"user_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Body != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 2000, "body", "must not be more than 2000 bytes long")
+}
+
+// Define a comment model which wraps a sql.DB connection pool. Like TodoModel
+// it only works once it has been scoped to an organization with ForOrg()
+type CommentModel struct {
+	DB    *sql.DB
+	OrgID int64
+}
+
+// ForOrg() returns a copy of the model scoped to an organization
+func (m CommentModel) ForOrg(orgID int64) CommentModel {
+	m.OrgID = orgID
+	return m
+}
+
+// Insert() adds a comment to a todo. It returns ErrRecordNotFound if the todo
+// is not one of the organization's
+func (m CommentModel) Insert(comment *Comment) error {
+	query := `
+		INSERT INTO todo_comments (todo_id, org_id, user_id, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	args := []interface{}{comment.TodoID, requireTenant(m.OrgID), comment.UserID, comment.Body}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), `violates foreign key constraint "todo_comments_todo_fkey"`) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// GetAll() returns the comments on a todo, oldest first
+func (m CommentModel) GetAll(todoID int64) ([]*Comment, error) {
+	query := `
+		SELECT id, todo_id, user_id, body, created_at
+		FROM todo_comments
+		WHERE todo_id = $1 AND org_id = $2
+		ORDER BY id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, todoID, requireTenant(m.OrgID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.TodoID, &comment.UserID, &comment.Body, &comment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
@@ -0,0 +1,109 @@
+// Filename: internal/data/dav_passwords.go
+
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// A DAVPassword is the app password a CalDAV client logs in with to sync the
+// todos of an organization. It is kept apart from the calendar feed token,
+// which only reads, since it can also change and delete todos
+type DAVPassword struct {
+	Password  string    `json:"password"`
+	UserID    int64     `json:"-"`
+	OrgID     int64     `json:"org_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateDAVPassword(v *validator.Validator, passwordPlaintext string) {
+	v.Check(passwordPlaintext != "", "password", "must be provided")
+	v.Check(len(passwordPlaintext) == 26, "password", "must be 26 bytes long")
+}
+
+// Define a CalDAV password model which wraps a sql.DB connection pool
+type DAVPasswordModel struct {
+	DB *sql.DB
+}
+
+// New() creates the app password of a user for an organization. An existing
+// password is replaced, so clients using it are logged out
+func (m DAVPasswordModel) New(userID int64, orgID int64) (*DAVPassword, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	password := &DAVPassword{Password: plaintext, UserID: userID, OrgID: orgID}
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+		INSERT INTO dav_passwords (hash, user_id, org_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, org_id) DO UPDATE SET hash = EXCLUDED.hash, created_at = NOW()
+		RETURNING created_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, hash[:], userID, orgID).Scan(&password.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return password, nil
+}
+
+// GetForPassword() returns the app password a plaintext password belongs to.
+// The returned password has no Password since only its hash is stored
+func (m DAVPasswordModel) GetForPassword(passwordPlaintext string) (*DAVPassword, error) {
+	hash := sha256.Sum256([]byte(passwordPlaintext))
+	query := `
+		SELECT user_id, org_id, created_at
+		FROM dav_passwords
+		WHERE hash = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	var password DAVPassword
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&password.UserID, &password.OrgID, &password.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &password, nil
+}
+
+// Delete() removes the app password of a user for an organization
+func (m DAVPasswordModel) Delete(userID int64, orgID int64) error {
+	query := `
+		DELETE FROM dav_passwords
+		WHERE user_id = $1 AND org_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, orgID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -3,6 +3,8 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"math"
 	"strings"
 
@@ -14,6 +16,45 @@ type Filters struct {
 	PageSize int
 	Sort     string
 	SortList []string
+	// Cursor is the next_cursor of the previous page. When it is set the
+	// listing continues after that row instead of using Page
+	Cursor string
+	// Total is how the matching records are counted
+	Total string
+}
+
+// The ways of counting the records of a listing. An exact count scans every
+// matching row, an estimate comes from the query planner and none only says
+// whether there is a next page
+const (
+	TotalExact    = "exact"
+	TotalEstimate = "estimate"
+	TotalNone     = "none"
+)
+
+// A cursor holds the sort key and id of the last row of a page. It is handed
+// to clients base64 encoded so they treat it as opaque
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// The encodeCursor() function turns a cursor into its opaque form
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// The decodeCursor() function reads a cursor made by encodeCursor()
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(js, &c)
+	return c, err
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -24,6 +65,14 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page", "maximum of 100")
 	//Check that the sort parameter matches a value in the acceptable sort list
 	v.Check(validator.In(f.Sort, f.SortList...), "sort", "invalid sort value")
+	v.Check(validator.In(f.Total, TotalExact, TotalEstimate, TotalNone), "total", "must be exact, estimate or none")
+	//A cursor only makes sense for the sort it was made for
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "is not valid")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "was made for a different sort")
+		v.Check(f.Page == 1, "page", "cannot be combined with cursor")
+	}
 }
 
 // The sortColumn() method safely extracts the sort field query parameter
@@ -44,6 +93,15 @@ func (f Filters) sortOrder() string {
 	return "ASC"
 }
 
+// The keysetOperator() method returns the comparison that selects the rows
+// after the cursor in the sort order
+func (f Filters) keysetOperator() string {
+	if f.sortOrder() == "DESC" {
+		return "<"
+	}
+	return ">"
+}
+
 // The limit() method determines the LIMIT
 func (f Filters) limit() int {
 	return f.PageSize
@@ -56,11 +114,14 @@ func (f Filters) offset() int {
 
 // The metadata type contains metadata to help with pagination
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	Total        string `json:"total"`
+	HasNext      bool   `json:"has_next"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 // The calculateMetaData() function computes the values for the Metadata fields
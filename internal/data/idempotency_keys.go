@@ -0,0 +1,180 @@
+// Filename: internal/data/idempotency_keys.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// IdempotencyKeyTTL is how long the response for a key is kept
+const IdempotencyKeyTTL = 24 * time.Hour
+
+var (
+	// ErrIdempotencyKeyReused is returned for a key that was used for a
+	// different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different request")
+	// ErrIdempotencyKeyInUse is returned while another request with the key
+	// is still being handled
+	ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+)
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 bytes long")
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			v.AddError("Idempotency-Key", "must only contain printable ASCII characters")
+			break
+		}
+	}
+}
+
+// An IdempotentResponse is the response that was sent for a key
+type IdempotentResponse struct {
+	Status  int
+	Headers map[string][]string
+	Body    []byte
+}
+
+// An IdempotencyClaim holds the lock on a key while its request is handled.
+// What the request writes goes through the claim's transaction, so it is
+// committed together with the response or not at all. Either Complete() or
+// Release() must be called
+type IdempotencyClaim struct {
+	tx     *sql.Tx
+	cancel context.CancelFunc
+	userID int64
+	key    string
+}
+
+// Define an idempotency key model which wraps a sql.DB connection pool
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Claim() takes the key of a user for a request with the fingerprint. If the
+// key already has a response, that is returned instead of a claim.
+//
+// The claim is a row that is inserted but not committed, so a concurrent
+// request with the same key waits on the row lock. It gets the response once
+// the claim is completed, or the claim itself if it was released
+func (m IdempotencyKeyModel) Claim(userID int64, key string, fingerprint []byte) (*IdempotencyClaim, *IdempotentResponse, error) {
+	// The transaction lives as long as the request it guards can take
+	txCtx, txCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	tx, err := m.DB.BeginTx(txCtx, nil)
+	if err != nil {
+		txCancel()
+		return nil, nil, err
+	}
+	claim := &IdempotencyClaim{tx: tx, cancel: txCancel, userID: userID, key: key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	// An expired key starts over as if it had never been used
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL, created_at = NOW()
+		WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $4)
+	`
+	_, err = tx.ExecContext(ctx, query, userID, key, fingerprint, IdempotencyKeyTTL.Seconds())
+	if err == nil {
+		query = `
+			SELECT fingerprint, status, headers, body
+			FROM idempotency_keys
+			WHERE user_id = $1 AND key = $2
+			FOR UPDATE
+		`
+		var stored []byte
+		var status sql.NullInt32
+		var headers, body []byte
+		err = tx.QueryRowContext(ctx, query, userID, key).Scan(&stored, &status, &headers, &body)
+		switch {
+		case err != nil:
+		case string(stored) != string(fingerprint):
+			err = ErrIdempotencyKeyReused
+		// Our own row, which has no response yet
+		case !status.Valid:
+			return claim, nil, nil
+		default:
+			response := &IdempotentResponse{Status: int(status.Int32), Body: body}
+			err = json.Unmarshal(headers, &response.Headers)
+			if err == nil {
+				claim.Release()
+				return nil, response, nil
+			}
+		}
+	}
+	claim.Release()
+	// Timing out means the request holding the key is still running
+	if ctx.Err() != nil {
+		return nil, nil, ErrIdempotencyKeyInUse
+	}
+	return nil, nil, err
+}
+
+// Todos() returns a copy of a todo model whose queries run in the claim's
+// transaction. The request must not use another connection while it holds
+// the claim, or a full pool would leave it waiting on itself
+func (c *IdempotencyClaim) Todos(m TodoModel) TodoModel {
+	m.tx = c.tx
+	return m
+}
+
+// Complete() stores the response for the key and commits it along with what
+// the request wrote. The response must only be sent once this succeeded
+func (c *IdempotencyClaim) Complete(response *IdempotentResponse) error {
+	defer c.cancel()
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		c.tx.Rollback()
+		return err
+	}
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	_, err = c.tx.ExecContext(ctx, query, c.userID, c.key, response.Status, headers, response.Body)
+	if err != nil {
+		c.tx.Rollback()
+		return err
+	}
+	return c.tx.Commit()
+}
+
+// Release() gives up the key without a response, so the request can be
+// tried again
+func (c *IdempotencyClaim) Release() error {
+	defer c.cancel()
+	return c.tx.Rollback()
+}
+
+// DeleteExpired() removes the keys that are older than IdempotencyKeyTTL
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < NOW() - make_interval(secs => $1)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, IdempotencyKeyTTL.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
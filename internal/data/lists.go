@@ -0,0 +1,144 @@
+// Filename: internal/data/lists.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// A List groups todos inside an organization
+type List struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+// Define a list model which wraps a sql.DB connection pool. Like TodoModel it
+// only works once it has been scoped to an organization with ForOrg()
+type ListModel struct {
+	DB    *sql.DB
+	OrgID int64
+}
+
+// ForOrg() returns a copy of the model scoped to an organization
+func (m ListModel) ForOrg(orgID int64) ListModel {
+	m.OrgID = orgID
+	return m
+}
+
+// Insert() creates a new list
+func (m ListModel) Insert(list *List) error {
+	query := `
+		INSERT INTO lists (org_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	args := []interface{}{requireTenant(m.OrgID), list.Name}
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.Version)
+}
+
+// Get() retrieves a specific list
+func (m ListModel) Get(id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, version
+		FROM lists
+		WHERE id = $1 AND org_id = $2
+	`
+	var list List
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, requireTenant(m.OrgID)).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.Name,
+		&list.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &list, nil
+}
+
+// GetAll() returns every list of the organization
+func (m ListModel) GetAll() ([]*List, error) {
+	query := `
+		SELECT id, created_at, name, version
+		FROM lists
+		WHERE org_id = $1
+		ORDER BY id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, requireTenant(m.OrgID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lists := []*List{}
+	for rows.Next() {
+		var list List
+		err := rows.Scan(&list.ID, &list.CreatedAt, &list.Name, &list.Version)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// Delete() removes a list along with its todos
+func (m ListModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM lists
+		WHERE id = $1 AND org_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, requireTenant(m.OrgID))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
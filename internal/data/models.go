@@ -2,6 +2,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -9,16 +10,58 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrInvalidList    = errors.New("invalid list")
+	ErrInvalidParent  = errors.New("invalid parent todo")
 )
 
+// dbtx is the part of *sql.DB and *sql.Tx that the models use, so a model
+// can run its queries either way
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // A wrapper for our data models
 type Models struct {
-	Todos TodoModel
+	Todos           TodoModel
+	Users           UserModel
+	RefreshTokens   RefreshTokenModel
+	Sessions        SessionModel
+	Organizations   OrganizationModel
+	Lists           ListModel
+	Phones          PhoneModel
+	Reminders       ReminderModel
+	CalendarFeeds   CalendarFeedModel
+	DAVPasswords    DAVPasswordModel
+	IdempotencyKeys IdempotencyKeyModel
+	Comments        CommentModel
 }
 
 // NewModels() allows us to create a new model
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Todos: TodoModel{DB: db},
+		Todos:           TodoModel{DB: db},
+		Users:           UserModel{DB: db},
+		RefreshTokens:   RefreshTokenModel{DB: db},
+		Sessions:        SessionModel{DB: db},
+		Organizations:   OrganizationModel{DB: db},
+		Lists:           ListModel{DB: db},
+		Phones:          PhoneModel{DB: db},
+		Reminders:       ReminderModel{DB: db},
+		CalendarFeeds:   CalendarFeedModel{DB: db},
+		DAVPasswords:    DAVPasswordModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		Comments:        CommentModel{DB: db},
+	}
+}
+
+// The requireTenant() function returns the organization a model is scoped to.
+// A tenant model that was never scoped is a bug that could leak data between
+// organizations, so it panics rather than run the query
+func requireTenant(orgID int64) int64 {
+	if orgID < 1 {
+		panic("data: query is not scoped to an organization")
 	}
+	return orgID
 }
@@ -0,0 +1,356 @@
+// Filename: internal/data/organizations.go
+
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+var (
+	ErrAlreadyMember = errors.New("already a member")
+)
+
+// The roles a user can have in an organization
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// A Membership links a user to an organization with a role
+type Membership struct {
+	OrgID     int64     `json:"org_id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CanManage() checks if the member may invite others to the organization
+func (m *Membership) CanManage() bool {
+	return m.Role == RoleOwner || m.Role == RoleAdmin
+}
+
+// An Invitation lets the holder of the plaintext token join an organization
+type Invitation struct {
+	Plaintext string    `json:"-"`
+	Hash      []byte    `json:"-"`
+	OrgID     int64     `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy int64     `json:"invited_by"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 200, "name", "must not be more than 200 bytes long")
+	// The name goes into the subject of invitation emails
+	v.Check(validator.NoControl(org.Name), "name", "must not contain control characters")
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+	v.Check(validator.In(invitation.Role, RoleAdmin, RoleMember), "role", "must be admin or member")
+}
+
+func ValidateInvitationTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+// Define an organization model which wraps a sql.DB connection pool
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates an organization with the given user as its owner
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if err = insertOrganization(ctx, tx, org, ownerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The insertOrganization() function creates an organization and its owner's
+// membership in the transaction
+func insertOrganization(ctx context.Context, tx *sql.Tx, org *Organization, ownerID int64) error {
+	query := `
+		INSERT INTO organizations (name)
+		VALUES ($1)
+		RETURNING id, created_at, version
+	`
+	err := tx.QueryRowContext(ctx, query, org.Name).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO org_members (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+	`
+	_, err = tx.ExecContext(ctx, query, org.ID, ownerID, RoleOwner)
+	if err != nil {
+		return err
+	}
+	org.Role = RoleOwner
+	return nil
+}
+
+// Get() retrieves a specific organization
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, version
+		FROM organizations
+		WHERE id = $1
+	`
+	var org Organization
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+// GetAllForUser() returns the organizations the user is a member of
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+		SELECT o.id, o.created_at, o.name, m.role, o.version
+		FROM organizations o
+		INNER JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY m.created_at ASC, o.id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orgs := []*Organization{}
+	for rows.Next() {
+		var org Organization
+		err := rows.Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Role, &org.Version)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// GetMembership() returns the user's membership of an organization
+func (m OrganizationModel) GetMembership(orgID int64, userID int64) (*Membership, error) {
+	if orgID < 1 || userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT org_id, user_id, role, created_at
+		FROM org_members
+		WHERE org_id = $1 AND user_id = $2
+	`
+	return m.getMembership(query, orgID, userID)
+}
+
+// GetDefaultMembership() returns the membership the user has held the
+// longest. It is used when a request does not name an organization
+func (m OrganizationModel) GetDefaultMembership(userID int64) (*Membership, error) {
+	query := `
+		SELECT org_id, user_id, role, created_at
+		FROM org_members
+		WHERE user_id = $1
+		ORDER BY created_at ASC, org_id ASC
+		LIMIT 1
+	`
+	return m.getMembership(query, userID)
+}
+
+func (m OrganizationModel) getMembership(query string, args ...interface{}) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	var membership Membership
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&membership.OrgID,
+		&membership.UserID,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &membership, nil
+}
+
+// GetMembers() lists the members of an organization
+func (m OrganizationModel) GetMembers(orgID int64) ([]*Membership, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.name, u.email, m.role, m.created_at
+		FROM org_members m
+		INNER JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.created_at ASC, m.user_id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []*Membership{}
+	for rows.Next() {
+		var member Membership
+		err := rows.Scan(
+			&member.OrgID,
+			&member.UserID,
+			&member.Name,
+			&member.Email,
+			&member.Role,
+			&member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// NewInvitation() creates and stores an invitation to the organization
+func (m OrganizationModel) NewInvitation(invitation *Invitation, ttl time.Duration) error {
+	plaintext, err := randomString()
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+	invitation.Plaintext = plaintext
+	invitation.Hash = hash[:]
+	invitation.Expiry = time.Now().Add(ttl)
+
+	query := `
+		INSERT INTO org_invitations (hash, org_id, email, role, invited_by, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	args := []interface{}{
+		invitation.Hash,
+		invitation.OrgID,
+		invitation.Email,
+		invitation.Role,
+		invitation.InvitedBy,
+		invitation.Expiry,
+	}
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// AcceptInvitation() adds the user to the organization the invitation is
+// for. The invitation must have been sent to the user's email address
+func (m OrganizationModel) AcceptInvitation(tokenPlaintext string, user *User) (*Membership, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Invitations are single use
+	query := `
+		DELETE FROM org_invitations
+		WHERE hash = $1 AND email = $2 AND expiry > NOW()
+		RETURNING org_id, role
+	`
+	membership := Membership{UserID: user.ID}
+	err = tx.QueryRowContext(ctx, query, hash[:], user.Email).Scan(&membership.OrgID, &membership.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	query = `
+		INSERT INTO org_members (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING created_at
+	`
+	err = tx.QueryRowContext(ctx, query, membership.OrgID, membership.UserID, membership.Role).Scan(&membership.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAlreadyMember
+		default:
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
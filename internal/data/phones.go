@@ -0,0 +1,203 @@
+// Filename: internal/data/phones.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+var (
+	ErrPhoneNotVerified  = errors.New("phone not verified")
+	ErrPhoneChanged      = errors.New("phone changed since the code was sent")
+	ErrTooManyPhoneCodes = errors.New("too many phone codes requested")
+)
+
+const (
+	// The number of wrong codes we accept before the code has to be requested again
+	maxPhoneCodeAttempts = 5
+	// The number of codes a user can request per hour. Each new code comes
+	// with new attempts, so this caps the guesses as well
+	maxPhoneCodesPerHour = 5
+)
+
+func ValidatePhone(v *validator.Validator, phone string) {
+	v.Check(phone != "", "phone", "must be provided")
+	v.Check(validator.Matches(phone, validator.PhoneRX), "phone", "must be a valid phone number")
+}
+
+func ValidatePhoneCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// Define a phone model which wraps a sql.DB connection pool
+type PhoneModel struct {
+	DB *sql.DB
+}
+
+// NewCode() replaces the user's phone number with an unverified one and
+// returns the one-time code that verifies it. A user who already asked for
+// maxPhoneCodesPerHour codes in the past hour gets ErrTooManyPhoneCodes
+func (m PhoneModel) NewCode(userID int64, phone string, ttl time.Duration) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	hash := sha256.Sum256([]byte(code))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// The count starts over once the hour since the first code is up
+	query := `
+		INSERT INTO phone_verifications (user_id, phone, hash, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET phone = EXCLUDED.phone, hash = EXCLUDED.hash, expiry = EXCLUDED.expiry, attempts = 0,
+			codes_sent = CASE WHEN phone_verifications.window_start > NOW() - INTERVAL '1 hour'
+				THEN phone_verifications.codes_sent + 1 ELSE 1 END,
+			window_start = CASE WHEN phone_verifications.window_start > NOW() - INTERVAL '1 hour'
+				THEN phone_verifications.window_start ELSE NOW() END
+		RETURNING codes_sent
+	`
+	var codesSent int
+	err = tx.QueryRowContext(ctx, query, userID, phone, hash[:], time.Now().Add(ttl)).Scan(&codesSent)
+	if err != nil {
+		return "", err
+	}
+	if codesSent > maxPhoneCodesPerHour {
+		return "", ErrTooManyPhoneCodes
+	}
+	// A new number has to be verified again and cannot receive reminders yet
+	query = `
+		UPDATE users
+		SET phone = $1, phone_verified = false, sms_reminders = false, version = version + 1
+		WHERE id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, phone, userID)
+	if err != nil {
+		return "", err
+	}
+	return code, tx.Commit()
+}
+
+// Verify() checks the code against the pending verification and marks the
+// phone number as verified. Wrong codes use up one of the attempts. If the
+// user's number is no longer the one the code was sent to, ErrPhoneChanged
+// is returned and nothing is verified
+func (m PhoneModel) Verify(userID int64, code string) error {
+	hash := sha256.Sum256([]byte(code))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		SELECT phone, hash, expiry, attempts
+		FROM phone_verifications
+		WHERE user_id = $1
+		FOR UPDATE
+	`
+	var (
+		phone    string
+		expected []byte
+		expiry   time.Time
+		attempts int
+	)
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&phone, &expected, &expiry, &attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if attempts >= maxPhoneCodeAttempts || time.Now().After(expiry) {
+		return ErrRecordNotFound
+	}
+	if subtle.ConstantTimeCompare(expected, hash[:]) != 1 {
+		_, err = tx.ExecContext(ctx, `UPDATE phone_verifications SET attempts = attempts + 1 WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		return ErrRecordNotFound
+	}
+	// The number must not have changed since the code was sent
+	query = `
+		UPDATE users
+		SET phone_verified = true, version = version + 1
+		WHERE id = $1 AND phone = $2
+	`
+	result, err := tx.ExecContext(ctx, query, userID, phone)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		return ErrPhoneChanged
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM phone_verifications WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetReminders() turns SMS reminders on or off. Only verified phone numbers
+// can receive them
+func (m PhoneModel) SetReminders(userID int64, enabled bool) error {
+	query := `
+		UPDATE users
+		SET sms_reminders = $1, version = version + 1
+		WHERE id = $2 AND (phone_verified OR NOT $1)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, enabled, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrPhoneNotVerified
+	}
+	return nil
+}
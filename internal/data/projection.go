@@ -0,0 +1,152 @@
+// Filename: internal/data/projection.go
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+// The fields of a todo a client can ask for with ?fields=
+var TodoFieldList = []string{"id", "list_id", "parent_id", "name", "task", "due", "version", "priority", "completed_at", "extensions"}
+
+// The related resources a client can embed with ?include=
+var TodoIncludeList = []string{"list", "tags", "comments_count", "subtasks"}
+
+// A Projection picks the fields of a todo to read and the related resources to
+// embed. Without any Fields every field is read
+type Projection struct {
+	Fields  []string
+	Include []string
+}
+
+func ValidateProjection(v *validator.Validator, p Projection) {
+	//Check the requested fields and relations against the allow lists
+	for _, field := range p.Fields {
+		v.Check(validator.In(field, TodoFieldList...), "fields", fmt.Sprintf("%q is not a todo field", field))
+	}
+	for _, include := range p.Include {
+		v.Check(validator.In(include, TodoIncludeList...), "include", fmt.Sprintf("%q cannot be included", include))
+	}
+}
+
+// The selects() method reports whether a field is read
+func (p Projection) selects(field string) bool {
+	return len(p.Fields) == 0 || validator.In(field, p.Fields...)
+}
+
+// The includes() method reports whether a related resource is embedded
+func (p Projection) includes(relation string) bool {
+	return validator.In(relation, p.Include...)
+}
+
+// The with() method returns a copy of the projection that also reads the
+// given fields
+func (p Projection) with(fields ...string) Projection {
+	if len(p.Fields) > 0 {
+		p.Fields = append(append([]string{}, p.Fields...), fields...)
+	}
+	return p
+}
+
+// The scan() method returns the select list for the projection and the
+// destinations to scan a row into. The relations are embedded with sub
+// queries so one query returns everything. finish() must be called after the
+// row has been scanned
+func (p Projection) scan(todo *Todo) (columns string, dest []interface{}, finish func() error) {
+	// The id and version are always read since the entity tag needs them
+	cols := []string{"todo.id", "todo.version"}
+	dest = []interface{}{&todo.ID, &todo.Version}
+	if len(p.Fields) == 0 {
+		cols = append(cols, "todo.created_at", "todo.updated_at", "todo.created_by", "todo.dav_name", "todo.ical_uid")
+		dest = append(dest, &todo.CreatedAt, &todo.UpdatedAt, &todo.CreatedBy, &todo.DAVName, &todo.UID)
+	}
+	if p.selects("list_id") {
+		cols = append(cols, "todo.list_id")
+		dest = append(dest, &todo.ListID)
+	}
+	if p.selects("parent_id") {
+		cols = append(cols, "todo.parent_id")
+		dest = append(dest, &todo.ParentID)
+	}
+	if p.selects("name") {
+		cols = append(cols, "todo.name")
+		dest = append(dest, &todo.Name)
+	}
+	if p.selects("task") {
+		cols = append(cols, "todo.task")
+		dest = append(dest, &todo.Task)
+	}
+	if p.selects("due") {
+		cols = append(cols, "todo.due_at")
+		dest = append(dest, &todo.Due)
+	}
+	if p.selects("priority") {
+		cols = append(cols, "COALESCE(todo.priority, '')")
+		dest = append(dest, &todo.Priority)
+	}
+	if p.selects("completed_at") {
+		cols = append(cols, "todo.completed_at")
+		dest = append(dest, &todo.CompletedAt)
+	}
+	if p.selects("extensions") {
+		cols = append(cols, "todo.extensions")
+		dest = append(dest, pq.Array(&todo.Extensions))
+	}
+
+	var list, subtasks []byte
+	var tags []string
+	var commentsCount int
+	if p.includes("list") {
+		cols = append(cols, `(SELECT row_to_json(l) FROM (
+			SELECT id, created_at, name, version FROM lists
+			WHERE lists.id = todo.list_id AND lists.org_id = todo.org_id) l)`)
+		dest = append(dest, &list)
+	}
+	if p.includes("tags") {
+		cols = append(cols, "ARRAY(SELECT tag FROM todo_tags WHERE todo_tags.todo_id = todo.id ORDER BY tag)")
+		dest = append(dest, pq.Array(&tags))
+	}
+	if p.includes("comments_count") {
+		cols = append(cols, "(SELECT COUNT(*) FROM todo_comments WHERE todo_comments.todo_id = todo.id)")
+		dest = append(dest, &commentsCount)
+	}
+	// Only the direct subtasks are embedded, with the fields of a todo
+	if p.includes("subtasks") {
+		cols = append(cols, `(SELECT COALESCE(json_agg(json_build_object(
+			'id', s.id, 'list_id', s.list_id, 'parent_id', s.parent_id, 'name', s.name, 'task', s.task,
+			'due', s.due_at, 'version', s.version, 'priority', s.priority, 'completed_at', s.completed_at
+			) ORDER BY s.id), '[]') FROM todo s WHERE s.parent_id = todo.id AND s.org_id = todo.org_id)`)
+		dest = append(dest, &subtasks)
+	}
+
+	finish = func() error {
+		if p.includes("list") && list != nil {
+			todo.List = &List{}
+			if err := json.Unmarshal(list, todo.List); err != nil {
+				return err
+			}
+		}
+		// Copy the values so rows scanned later do not overwrite them
+		if p.includes("tags") {
+			rowTags := append([]string{}, tags...)
+			todo.Tags = &rowTags
+		}
+		if p.includes("comments_count") {
+			rowCount := commentsCount
+			todo.CommentsCount = &rowCount
+		}
+		if p.includes("subtasks") {
+			todo.Subtasks = &[]*Todo{}
+			if err := json.Unmarshal(subtasks, todo.Subtasks); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return strings.Join(cols, ", "), dest, finish
+}
@@ -0,0 +1,171 @@
+// Filename: internal/data/refresh_tokens.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"todo.jamesfaber.net/internal/validator"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// A refresh token can be exchanged exactly once for a new access token and
+// a new refresh token from the same family
+type RefreshToken struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Family    string    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// The randomString() function returns a base32 encoded string built from
+// 16 bytes of randomness
+func randomString() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+func generateRefreshToken(userID int64, family string, ttl time.Duration) (*RefreshToken, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(plaintext))
+	token := &RefreshToken{
+		Plaintext: plaintext,
+		Hash:      hash[:],
+		UserID:    userID,
+		Family:    family,
+		Expiry:    time.Now().Add(ttl),
+	}
+	return token, nil
+}
+
+func ValidateRefreshTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "refresh_token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "refresh_token", "must be 26 bytes long")
+}
+
+// Define a refresh token model which wraps a sql.DB connection pool
+type RefreshTokenModel struct {
+	DB *sql.DB
+}
+
+// New() returns the first refresh token of a session
+func (m RefreshTokenModel) New(userID int64, session string, ttl time.Duration) (*RefreshToken, error) {
+	token, err := generateRefreshToken(userID, session, ttl)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(token)
+	return token, err
+}
+
+// Insert() stores the hash of a refresh token
+func (m RefreshTokenModel) Insert(token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (hash, user_id, family, expiry)
+		VALUES ($1, $2, $3, $4)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	args := []interface{}{token.Hash, token.UserID, token.Family, token.Expiry}
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Rotate() marks the presented token as used and returns its successor. If
+// the token was already used somebody is replaying it, so the whole family
+// is revoked and ErrRefreshTokenReused is returned, along with a token that
+// only names the user and the revoked family
+func (m RefreshTokenModel) Rotate(tokenPlaintext string, ttl time.Duration) (*RefreshToken, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Lock the row so two concurrent refreshes cannot both succeed
+	query := `
+		SELECT user_id, family, expiry, used_at
+		FROM refresh_tokens
+		WHERE hash = $1
+		FOR UPDATE
+	`
+	var (
+		userID int64
+		family string
+		expiry time.Time
+		usedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, query, hash[:]).Scan(&userID, &family, &expiry, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	// Reuse detected, revoke the session. Its tokens go with it
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, family)
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &RefreshToken{UserID: userID, Family: family}, ErrRefreshTokenReused
+	}
+	if time.Now().After(expiry) {
+		return nil, ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE hash = $1`, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE sessions SET last_used_at = NOW() WHERE id = $1`, family)
+	if err != nil {
+		return nil, err
+	}
+	token, err := generateRefreshToken(userID, family, ttl)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		INSERT INTO refresh_tokens (hash, user_id, family, expiry)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Family, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,87 @@
+// Filename: internal/data/reminders.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A Reminder tells the creator of a todo that it is due soon
+type Reminder struct {
+	TodoID int64
+	Name   string
+	Due    time.Time
+	Phone  string
+}
+
+// Define a reminder model which wraps a sql.DB connection pool. Unlike the
+// tenant models it works across organizations, but it only ever hands out
+// a todo to the user that created it
+type ReminderModel struct {
+	DB *sql.DB
+}
+
+// ClaimDue() returns the todos that are due within the lead time and whose
+// creator opted in to SMS reminders. Claimed todos are marked as reminded in
+// the same statement so that two instances never send the same reminder
+func (m ReminderModel) ClaimDue(lead time.Duration, limit int) ([]*Reminder, error) {
+	query := `
+		UPDATE todo t
+		SET reminded_at = NOW()
+		FROM users u
+		WHERE t.id IN (
+			SELECT d.id
+			FROM todo d
+			INNER JOIN users du ON du.id = d.created_by
+			WHERE d.reminded_at IS NULL
+			AND d.due_at <= NOW() + make_interval(secs => $1)
+			AND d.due_at > NOW() - make_interval(secs => $1)
+			AND du.phone_verified AND du.sms_reminders
+			ORDER BY d.due_at ASC
+			LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		AND u.id = t.created_by
+		RETURNING t.id, t.name, t.due_at, u.phone
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, lead.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reminders := []*Reminder{}
+	for rows.Next() {
+		var reminder Reminder
+		err := rows.Scan(&reminder.TodoID, &reminder.Name, &reminder.Due, &reminder.Phone)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, &reminder)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+// Release() hands a claimed todo back so that the next round claims it
+// again. It is for reminders that could not be sent
+func (m ReminderModel) Release(todoID int64) error {
+	query := `
+		UPDATE todo
+		SET reminded_at = NULL
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, todoID)
+	return err
+}
@@ -0,0 +1,174 @@
+// Filename: internal/data/sessions.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A session is started each time a user logs in. Its id doubles as the
+// family of the refresh tokens issued for it
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+}
+
+// Define a session model which wraps a sql.DB connection pool
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// New() creates and stores a new session for the user
+func (m SessionModel) New(userID int64, userAgent string, ip string) (*Session, error) {
+	id, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:        id,
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+	query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at, last_used_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	args := []interface{}{session.ID, session.UserID, session.UserAgent, session.IP}
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetAllForUser() returns the user's sessions, most recently used first
+func (m SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, created_at, last_used_at, user_agent, ip
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY last_used_at DESC, id ASC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.UserAgent,
+			&session.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Delete() revokes one of the user's sessions along with its refresh tokens
+func (m SessionModel) Delete(userID int64, id string) error {
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUserExcept() revokes every session of the user but the one
+// given and returns the ids of the revoked sessions
+func (m SessionModel) DeleteAllForUserExcept(userID int64, keep string) ([]string, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = $1 AND id <> $2
+		RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, keep)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Touch() records when each session was last used. It takes a batch so the
+// callers can collect usage in memory and write it out periodically
+func (m SessionModel) Touch(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(lastUsed))
+	times := make([]string, 0, len(lastUsed))
+	for id, t := range lastUsed {
+		ids = append(ids, id)
+		times = append(times, t.Format(time.RFC3339))
+	}
+	query := `
+		UPDATE sessions s
+		SET last_used_at = GREATEST(s.last_used_at, u.last_used_at)
+		FROM unnest($1::text[], $2::timestamptz[]) AS u(id, last_used_at)
+		WHERE s.id = u.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids), pq.Array(times))
+	return err
+}
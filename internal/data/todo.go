@@ -5,19 +5,41 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"todo.jamesfaber.net/internal/validator"
 )
 
 type Todo struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Name      string    `json:"name"`
-	Task      string    `json:"task"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
+	CreatedBy *int64     `json:"-"`
+	ListID    *int64     `json:"list_id,omitempty"`
+	ParentID  *int64     `json:"parent_id,omitempty"`
+	Name      string     `json:"name"`
+	Task      string     `json:"task"`
+	Due       *time.Time `json:"due,omitempty"`
+	Version   int32      `json:"version"`
+	// Fields that come from the todo.txt format
+	Priority    string     `json:"priority,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Extensions  []string   `json:"extensions,omitempty"`
+	// The resource name and UID a CalDAV client gave the todo
+	DAVName *string `json:"-"`
+	UID     *string `json:"-"`
+	// Related resources, only set when asked for with a Projection
+	List          *List     `json:"list,omitempty"`
+	Tags          *[]string `json:"tags,omitempty"`
+	CommentsCount *int      `json:"comments_count,omitempty"`
+	Subtasks      *[]*Todo  `json:"subtasks,omitempty"`
 }
 
 func ValidateTodo(v *validator.Validator, todo *Todo) {
@@ -27,53 +49,301 @@ func ValidateTodo(v *validator.Validator, todo *Todo) {
 
 	v.Check(todo.Task != "", "task", "must be provided")
 	v.Check(len(todo.Task) <= 200, "task", "must not be more than 200 bytes long")
+
+	v.Check(todo.ListID == nil || *todo.ListID > 0, "list_id", "must be greater than zero")
+
+	v.Check(todo.ParentID == nil || *todo.ParentID > 0, "parent_id", "must be greater than zero")
+	v.Check(todo.ParentID == nil || *todo.ParentID != todo.ID, "parent_id", "must not be the todo itself")
+
+	v.Check(todo.Priority == "" || validator.Matches(todo.Priority, priorityRX), "priority", "must be a capital letter")
+}
+
+// Priorities go from A (highest) to Z
+var priorityRX = regexp.MustCompile(`^[A-Z]$`)
+
+// ETag() returns the entity tag of the todo. The version goes up with every
+// update, so the tag changes whenever the todo does
+func (t *Todo) ETag() string {
+	return fmt.Sprintf(`"%d-%d"`, t.ID, t.Version)
 }
 
-// Define a todo list model which wraps a sql.DB connection pool
+// Define a todo list model which wraps a sql.DB connection pool. Every query
+// is limited to the organization the model was scoped to with ForOrg()
 type TodoModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	OrgID int64
+	// Set while the model runs inside InTx()
+	tx *sql.Tx
+	// The fields and relations Get() and GetAll() read
+	projection Projection
+	// Observe is told how long each query took, if it is set
+	Observe func(query string, duration time.Duration)
+}
+
+// The observe() method reports the time since start to Observe. It is
+// deferred at the top of every query method
+func (m TodoModel) observe(query string, start time.Time) {
+	if m.Observe != nil {
+		m.Observe(query, time.Since(start))
+	}
+}
+
+// ForOrg() returns a copy of the model scoped to an organization
+func (m TodoModel) ForOrg(orgID int64) TodoModel {
+	m.OrgID = orgID
+	return m
+}
+
+// WithProjection() returns a copy of the model that only reads the fields and
+// embeds the relations of the projection
+func (m TodoModel) WithProjection(p Projection) TodoModel {
+	m.projection = p
+	return m
+}
+
+// The db() method returns the transaction the model runs in, if any, and the
+// connection pool otherwise
+func (m TodoModel) db() dbtx {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// InTx() runs fn with a copy of the model whose queries all go through one
+// transaction. The transaction is committed if fn returns nil and rolled
+// back otherwise
+func (m TodoModel) InTx(fn func(TodoModel) error) error {
+	// The transaction lives as long as this context does
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	return m.InTxContext(ctx, fn)
+}
+
+// InTxContext() is InTx() for transactions that need more than 30 seconds.
+// The transaction is rolled back if ctx is done before it is committed
+func (m TodoModel) InTxContext(ctx context.Context, fn func(TodoModel) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	m.tx = tx
+	if err = fn(m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Savepoint() runs fn inside a savepoint of the model's transaction. If fn
+// fails only its own work is undone and the transaction can carry on
+func (m TodoModel) Savepoint(fn func() error) error {
+	if m.tx == nil {
+		return fn()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	if _, err := m.tx.ExecContext(ctx, "SAVEPOINT todo_savepoint"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rollbackErr := m.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT todo_savepoint"); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	_, err := m.tx.ExecContext(ctx, "RELEASE SAVEPOINT todo_savepoint")
+	return err
+}
+
+// The listError() function turns a violation of the todo_list_fkey constraint
+// into ErrInvalidList, and one of todo_parent_fkey into ErrInvalidParent. The
+// constraints also cover lists and todos of other organizations
+func listError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), `violates foreign key constraint "todo_list_fkey"`):
+		return ErrInvalidList
+	case strings.Contains(err.Error(), `violates foreign key constraint "todo_parent_fkey"`):
+		return ErrInvalidParent
+	}
+	return err
 }
 
 // Insert() allows us to create a new todo task
 func (m TodoModel) Insert(todo *Todo) error {
+	defer m.observe("insert", time.Now())
 	query := `
-	INSERT INTO todo (name, task)
-	VALUES ($1, $2)
-	RETURNING id, created_at, version
+	INSERT INTO todo (org_id, created_by, list_id, name, task, due_at, priority, completed_at, extensions, created_at, dav_name, ical_uid, parent_id)
+	VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, COALESCE($9, '{}'), COALESCE($10, NOW()), $11, $12, $13)
+	RETURNING id, created_at, updated_at, version
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
 
 	// Collect the data fields into a slice
-	args := []interface{}{todo.Name, todo.Task}
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.Version)
+	// A todo without a creation time is created now
+	var createdAt *time.Time
+	if !todo.CreatedAt.IsZero() {
+		createdAt = &todo.CreatedAt
+	}
+	args := []interface{}{
+		requireTenant(m.OrgID), todo.CreatedBy, todo.ListID, todo.Name, todo.Task, todo.Due,
+		todo.Priority, todo.CompletedAt, pq.Array(todo.Extensions), createdAt, todo.DAVName, todo.UID,
+		todo.ParentID,
+	}
+	err := m.db().QueryRowContext(ctx, query, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.UpdatedAt, &todo.Version)
+	return listError(err)
+}
+
+// CopyIn() inserts a batch of todos with COPY, which is much faster than an
+// INSERT per todo. It only works inside InTx(). COPY cannot return anything,
+// so the ids of the new todos are taken from the sequence up front and set on
+// the todos
+func (m TodoModel) CopyIn(todos []*Todo) error {
+	defer m.observe("copy_in", time.Now())
+	if m.tx == nil {
+		return errors.New("CopyIn() must run inside InTx()")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.tx.QueryContext(ctx, `
+		SELECT nextval(pg_get_serial_sequence('todo', 'id'))
+		FROM generate_series(1, $1)`, len(todos))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for i := 0; rows.Next(); i++ {
+		if err := rows.Scan(&todos[i].ID); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := m.tx.PrepareContext(ctx, pq.CopyIn("todo", "id", "org_id", "created_by", "list_id", "name", "task", "due_at", "priority", "completed_at", "extensions", "created_at"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	orgID := requireTenant(m.OrgID)
+	now := time.Now()
+	for _, todo := range todos {
+		// COPY skips the defaults Insert() falls back to
+		var priority *string
+		if todo.Priority != "" {
+			priority = &todo.Priority
+		}
+		extensions := todo.Extensions
+		if extensions == nil {
+			extensions = []string{}
+		}
+		if todo.CreatedAt.IsZero() {
+			todo.CreatedAt = now
+		}
+		_, err = stmt.ExecContext(ctx, todo.ID, orgID, todo.CreatedBy, todo.ListID, todo.Name, todo.Task, todo.Due,
+			priority, todo.CompletedAt, pq.Array(extensions), todo.CreatedAt)
+		if err != nil {
+			return listError(err)
+		}
+	}
+	// The rows are sent when the statement is executed without arguments
+	_, err = stmt.ExecContext(ctx)
+	return listError(err)
+}
+
+// CopyTags() adds tags to todos that were just added by CopyIn(), again with
+// COPY. It only works inside InTx()
+func (m TodoModel) CopyTags(tags map[int64][]string) error {
+	defer m.observe("copy_tags", time.Now())
+	if m.tx == nil {
+		return errors.New("CopyTags() must run inside InTx()")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	stmt, err := m.tx.PrepareContext(ctx, pq.CopyIn("todo_tags", "todo_id", "tag"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for id, list := range tags {
+		// COPY has no ON CONFLICT, so a tag given twice is only sent once
+		seen := make(map[string]bool, len(list))
+		for _, tag := range list {
+			if seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			if _, err = stmt.ExecContext(ctx, id, tag); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// SetTags() replaces the tags of a todo
+func (m TodoModel) SetTags(id int64, tags []string) error {
+	defer m.observe("set_tags", time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	// Only touch todos of the model's organization
+	_, err := m.db().ExecContext(ctx, `
+		DELETE FROM todo_tags
+		WHERE todo_id = (SELECT id FROM todo WHERE id = $1 AND org_id = $2)`,
+		id, requireTenant(m.OrgID))
+	if err != nil {
+		return err
+	}
+	_, err = m.db().ExecContext(ctx, `
+		INSERT INTO todo_tags (todo_id, tag)
+		SELECT todo.id, tag FROM todo, unnest($3::text[]) AS tag
+		WHERE todo.id = $1 AND todo.org_id = $2
+		ON CONFLICT DO NOTHING`,
+		id, requireTenant(m.OrgID), pq.Array(tags))
+	return err
 }
 
 // GET() allows us to retrieve a specific todo item
 func (m TodoModel) Get(id int64) (*Todo, error) {
+	defer m.observe("get", time.Now())
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	// Create query
-	query := `
-		SELECT id, created_at, name, task, version
-		FROM todo
-		WHERE id = $1
-	`
 	// Declare a Todo variable to hold the return data
 	var todo Todo
+	columns, dest, finish := m.projection.scan(&todo)
+	// Create query
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo
+		WHERE id = $1 AND org_id = $2
+	`, columns)
 	// Execute Query using the QueryRow
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&todo.ID,
-		&todo.CreatedAt,
-		&todo.Name,
-		&todo.Task,
-		&todo.Version,
-	)
+	err := m.db().QueryRowContext(ctx, query, id, requireTenant(m.OrgID)).Scan(dest...)
+	if err == nil {
+		err = finish()
+	}
 	// Handle any errors
 	if err != nil {
 		// Check the type of error
@@ -90,39 +360,78 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 
 // Update() allows us to edit/alter a todo item in the list
 func (m TodoModel) Update(todo *Todo) error {
+	defer m.observe("update", time.Now())
 	query := `
 		UPDATE todo 
-		set name = $1, task = $2,  
-		version = version + 1
-		WHERE id = $3
-		AND version = $4
-		RETURNING version
+		set list_id = $1, name = $2, task = $3, due_at = $7,
+		priority = NULLIF($8, ''), completed_at = $9, parent_id = $10,
+		reminded_at = CASE WHEN due_at IS DISTINCT FROM $7 THEN NULL ELSE reminded_at END,
+		version = version + 1, updated_at = NOW()
+		WHERE id = $4
+		AND version = $5
+		AND org_id = $6
+		RETURNING version, updated_at
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
 
 	args := []interface{}{
+		todo.ListID,
 		todo.Name,
 		todo.Task,
 		todo.ID,
 		todo.Version,
+		requireTenant(m.OrgID),
+		todo.Due,
+		todo.Priority,
+		todo.CompletedAt,
+		todo.ParentID,
+	}
+	if todo.ParentID != nil {
+		cycle, err := m.isSubtask(ctx, *todo.ParentID, todo.ID)
+		if err != nil {
+			return err
+		}
+		// A todo cannot be moved under one of its own subtasks
+		if cycle {
+			return ErrInvalidParent
+		}
 	}
 	// Check for edit conflicts
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.Version)
+	err := m.db().QueryRowContext(ctx, query, args...).Scan(&todo.Version, &todo.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return listError(err)
 		}
 	}
 	return nil
 }
 
+// The isSubtask() method reports whether the todo id is ancestor or sits
+// anywhere below it. It walks up the parents of id, and UNION stops it at a
+// todo it has already seen
+func (m TodoModel) isSubtask(ctx context.Context, id int64, ancestor int64) (bool, error) {
+	query := `
+		WITH RECURSIVE ancestors (id) AS (
+			SELECT $1::bigint
+			UNION
+			SELECT todo.parent_id FROM todo JOIN ancestors ON todo.id = ancestors.id
+			WHERE todo.org_id = $3 AND todo.parent_id IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)
+	`
+	var found bool
+	err := m.db().QueryRowContext(ctx, query, id, ancestor, requireTenant(m.OrgID)).Scan(&found)
+	return found, err
+}
+
 // Delete() removes a specific Task
 func (m TodoModel) Delete(id int64) error {
+	defer m.observe("delete", time.Now())
 	// Ensure that there is a valid id
 	if id < 1 {
 		return ErrRecordNotFound
@@ -130,7 +439,7 @@ func (m TodoModel) Delete(id int64) error {
 	// Create the delete query
 	query := `
 		DELETE FROM todo
-		WHERE id = $1
+		WHERE id = $1 AND org_id = $2
 	`
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -138,7 +447,7 @@ func (m TodoModel) Delete(id int64) error {
 	defer cancel()
 
 	// Execute the query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.db().ExecContext(ctx, query, id, requireTenant(m.OrgID))
 	if err != nil {
 		return err
 	}
@@ -155,56 +464,227 @@ func (m TodoModel) Delete(id int64) error {
 	return nil
 }
 
-// the GetAll() method returns a list of all the Todo sorted by id
+// DeleteVersion() removes a specific Task only if it is still at the given
+// version. It returns ErrEditConflict if the todo has changed or is gone
+func (m TodoModel) DeleteVersion(id int64, version int32) error {
+	defer m.observe("delete_version", time.Now())
+	query := `
+		DELETE FROM todo
+		WHERE id = $1 AND org_id = $2 AND version = $3
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	result, err := m.db().ExecContext(ctx, query, id, requireTenant(m.OrgID), version)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// The sortValue() method returns the value of a sort column as it is kept in
+// a cursor
+func (t *Todo) sortValue(column string) string {
+	switch column {
+	case "name":
+		return t.Name
+	case "task":
+		return t.Task
+	default:
+		return strconv.FormatInt(t.ID, 10)
+	}
+}
+
+// The todos of an organization that match a name and task search. It takes
+// the organization, name and task as $1, $2 and $3
+const todoSearch = `
+		WHERE org_id = $1
+		AND (to_tsvector('simple',name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND (to_tsvector('simple',task) @@ plainto_tsquery('simple', $3) OR $3 = '')`
+
+// the GetAll() method returns a list of all the Todo sorted by id. With a
+// cursor the listing carries on after the cursor's row, which keeps deep pages
+// fast and does not skip or repeat rows when todos are added in between
 func (m TodoModel) GetAll(name string, task string, filters Filters) ([]*Todo, Metadata, error) {
+	defer m.observe("get_all", time.Now())
+	where := todoSearch
+	searchArgs := []interface{}{requireTenant(m.OrgID), name, task}
+
+	// Fetch one row more than the page holds to find out if there is a next page
+	args := append(searchArgs, filters.limit()+1)
+	// Either continue after the cursor or skip the earlier pages
+	keyset, offset := "", "OFFSET $5"
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		// Rows with the same sort value are ordered by id in the same
+		// direction, so one row comparison seeks straight to the cursor on
+		// the (org_id, column, id) index
+		keyset = fmt.Sprintf("AND (%s, id) %s ($5, $6)", filters.sortColumn(), filters.keysetOperator())
+		offset = ""
+		args = append(args, c.Value, c.ID)
+	} else {
+		args = append(args, filters.offset())
+	}
 	//construct the query to return all todo
 	//make query into formated string to be able to sort by field and asc or dec dynaimicaly
+	// The sort column is always read so the next cursor can be made
+	var todo Todo
+	columns, dest, finish := m.projection.with(filters.sortColumn()).scan(&todo)
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(),id, created_at, name, task, version
+		SELECT %s
 		FROM todo
-		WHERE (to_tsvector('simple',name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (to_tsvector('simple',task) @@ plainto_tsquery('simple', $2) OR $2 = '')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortOrder())
+		%s
+		%s
+		ORDER BY %[4]s %[5]s, id %[5]s
+		LIMIT $4 %[6]s`, columns, where, keyset, filters.sortColumn(), filters.sortOrder(), offset)
 
 	//create a 3 second timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	//execute the query
-	args := []interface{}{name, task, filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.db().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 	//close the result set
 	defer rows.Close()
-	//store total records
-	totalRecords := 0
 	//intialize an empty slice to hold the Todo data
 	todos := []*Todo{}
 	//iterate over the rows in the result set
 	for rows.Next() {
-		var todo Todo
 		//scan the values from the row into the Todo struct
-		err := rows.Scan(
-			&totalRecords,
-			&todo.ID,
-			&todo.CreatedAt,
-			&todo.Name,
-			&todo.Task,
-			&todo.Version,
-		)
+		todo = Todo{}
+		err := rows.Scan(dest...)
+		if err == nil {
+			err = finish()
+		}
 		if err != nil {
 			return nil, Metadata{}, err
 		}
-		//add the todo to our slice
-		todos = append(todos, &todo)
+		//add a copy of the todo to our slice
+		row := todo
+		todos = append(todos, &row)
 	}
 	//check if any errors occured while proccessing the result set
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
-	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	// Drop the extra row again
+	hasNext := len(todos) > filters.limit()
+	if hasNext {
+		todos = todos[:filters.limit()]
+	}
+
+	// Count the matching todos the way the client asked for
+	var metadata Metadata
+	switch filters.Total {
+	case TotalExact:
+		var totalRecords int
+		err = m.db().QueryRowContext(ctx, "SELECT COUNT(*) FROM todo"+where, searchArgs...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		metadata = calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	case TotalEstimate:
+		totalRecords, err := m.estimate(ctx, "SELECT 1 FROM todo"+where, searchArgs...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		metadata = calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	default:
+		metadata = Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}
+	}
+	// A cursor page has no page number
+	if filters.Cursor != "" {
+		metadata.CurrentPage = 0
+		metadata.LastPage = 0
+	}
+	metadata.Total = filters.Total
+	metadata.HasNext = hasNext
+	// Hand out a cursor for the rest of the listing, whichever way this page
+	// was reached
+	if hasNext {
+		last := todos[len(todos)-1]
+		metadata.NextCursor = encodeCursor(cursor{Sort: filters.Sort, Value: last.sortValue(filters.sortColumn()), ID: last.ID})
+	}
 	//return the result set. the slice of todos
 	return todos, metadata, nil
 }
+
+// ExportTimeout is how long Export() may take
+const ExportTimeout = 5 * time.Minute
+
+// Export() calls fn with every todo that matches the search, in the order of
+// the filters' sort. Rows are handed over as they are read so the whole
+// listing is never held in memory, and fn must not keep the todo since it is
+// reused for the next row. Only the sort of the filters is used
+func (m TodoModel) Export(name string, task string, filters Filters, fn func(*Todo) error) error {
+	defer m.observe("export", time.Now())
+	var todo Todo
+	columns, dest, finish := m.projection.scan(&todo)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo
+		%s
+		ORDER BY %[3]s %[4]s, id %[4]s`, columns, todoSearch, filters.sortColumn(), filters.sortOrder())
+
+	// Exports can be large so they get more time than other queries
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	rows, err := m.db().QueryContext(ctx, query, requireTenant(m.OrgID), name, task)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		todo = Todo{}
+		err := rows.Scan(dest...)
+		if err == nil {
+			err = finish()
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(&todo); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// The estimate() method returns the number of rows the query planner expects
+// a query to return. It comes from the table statistics, so it costs next to
+// nothing but can be off until the table is next analyzed
+func (m TodoModel) estimate(ctx context.Context, query string, args ...interface{}) (int, error) {
+	var plan []byte
+	err := m.db().QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&plan)
+	if err != nil {
+		return 0, err
+	}
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	err = json.Unmarshal(plan, &explained)
+	if err != nil {
+		return 0, err
+	}
+	if len(explained) == 0 {
+		return 0, errors.New("empty query plan")
+	}
+	return int(explained[0].Plan.Rows), nil
+}
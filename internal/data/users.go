@@ -0,0 +1,207 @@
+// Filename: internal/data/users.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"todo.jamesfaber.net/internal/validator"
+)
+
+var (
+	ErrDuplicateEmail = errors.New("duplicate email")
+)
+
+// AnonymousUser represents a client that did not authenticate
+var AnonymousUser = &User{}
+
+type User struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Password      password  `json:"-"`
+	Phone         string    `json:"phone,omitempty"`
+	PhoneVerified bool      `json:"phone_verified"`
+	SMSReminders  bool      `json:"sms_reminders"`
+	Version       int       `json:"-"`
+}
+
+// IsAnonymous() checks if the user is the AnonymousUser
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// The password type holds the plaintext password (only available while the
+// request is being processed) and its bcrypt hash
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+// Set() stores the hash of the plaintext password
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+	return nil
+}
+
+// Matches() checks if the plaintext password matches the stored hash
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	// The name is also the name of the user's first organization
+	v.Check(validator.NoControl(user.Name), "name", "must not contain control characters")
+
+	ValidateEmail(v, user.Email)
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+	// A missing hash is a bug in our code, not the client's fault
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+// Define a user model which wraps a sql.DB connection pool
+type UserModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates a new user along with the organization they own. Both are
+// created in one transaction, so there is never a user without one
+func (m UserModel) Insert(user *User, org *Organization) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (name, email, password_hash)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version
+	`
+	args := []interface{}{user.Name, user.Email, user.Password.hash}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	if err = insertOrganization(ctx, tx, org, user.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetByEmail() retrieves a user by their email address
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, phone, phone_verified, sms_reminders, version
+		FROM users
+		WHERE email = $1
+	`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Phone,
+		&user.PhoneVerified,
+		&user.SMSReminders,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Get() retrieves a user by their id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, email, password_hash, phone, phone_verified, sms_reminders, version
+		FROM users
+		WHERE id = $1
+	`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Phone,
+		&user.PhoneVerified,
+		&user.SMSReminders,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
@@ -0,0 +1,277 @@
+// Filename: internal/ical/ical.go
+
+// Package ical reads and writes the parts of iCalendar (RFC 5545) that we
+// need for VTODO components
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The layouts of DATE-TIME and DATE values
+const (
+	DateTimeLayout = "20060102T150405Z"
+	DateLayout     = "20060102"
+)
+
+// ErrInvalidCalendar is returned for input that is not an iCalendar object
+var ErrInvalidCalendar = errors.New("ical: invalid calendar")
+
+// A Property is one content line, e.g. DUE;VALUE=DATE:20261020
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// A Component is a BEGIN/END block such as VCALENDAR or VTODO
+type Component struct {
+	Name       string
+	Line       int
+	Properties []Property
+	Components []*Component
+}
+
+// Get() returns the first property with the name
+func (c *Component) Get(name string) (Property, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Parse() reads an iCalendar object and returns its outermost component
+func Parse(r io.Reader) (*Component, error) {
+	d := NewDecoder(r)
+	for {
+		c, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			return d.Root(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.root.Components = append(d.root.Components, c)
+	}
+}
+
+// A Decoder reads an iCalendar object one component at a time, so a large
+// calendar never has to be held in memory. The components directly inside the
+// outermost one are handed out by Next() as soon as they end
+type Decoder struct {
+	s           *bufio.Scanner
+	line        int
+	logical     string
+	logicalLine int
+	at          int
+	stack       []*Component
+	root        *Component
+	ready       *Component
+	done        bool
+	err         error
+}
+
+// NewDecoder() returns a Decoder that reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1_048_576)
+	return &Decoder{s: s}
+}
+
+// Root() returns the outermost component without the components inside it.
+// It is nil until Next() has read its BEGIN line
+func (d *Decoder) Root() *Component {
+	return d.root
+}
+
+// Line() returns the line the last content line read in full starts on.
+// After an error it is the line the error is on
+func (d *Decoder) Line() int {
+	return d.at
+}
+
+// Next() returns the next component inside the outermost one, or io.EOF once
+// the object has ended. An error stops the decoder for good
+func (d *Decoder) Next() (*Component, error) {
+	for d.ready == nil && d.err == nil {
+		if d.done {
+			return nil, io.EOF
+		}
+		if !d.s.Scan() {
+			d.err = d.s.Err()
+			if d.err == nil {
+				d.err = d.handle(d.logical, d.logicalLine)
+			}
+			if d.err == nil && (d.root == nil || len(d.stack) > 0) {
+				d.err = fmt.Errorf("%w: missing BEGIN or END", ErrInvalidCalendar)
+			}
+			d.done = true
+			continue
+		}
+		d.line++
+		text := strings.TrimRight(d.s.Text(), "\r")
+		// Lines starting with a space or tab continue the one before
+		if strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t") {
+			d.logical += text[1:]
+			continue
+		}
+		d.err = d.handle(d.logical, d.logicalLine)
+		d.logical, d.logicalLine = text, d.line
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	c := d.ready
+	d.ready = nil
+	return c, nil
+}
+
+// The handle() method adds a logical line to the component being read
+func (d *Decoder) handle(content string, at int) error {
+	if content == "" {
+		return nil
+	}
+	d.at = at
+	p, err := parseProperty(content)
+	if err != nil {
+		return fmt.Errorf("%w: line %d: %s", ErrInvalidCalendar, at, err)
+	}
+	switch p.Name {
+	case "BEGIN":
+		c := &Component{Name: strings.ToUpper(p.Value), Line: at}
+		if len(d.stack) > 0 {
+			// The root's own components are handed out instead of kept
+			if parent := d.stack[len(d.stack)-1]; parent != d.root {
+				parent.Components = append(parent.Components, c)
+			}
+		} else if d.root == nil {
+			d.root = c
+		}
+		d.stack = append(d.stack, c)
+	case "END":
+		if len(d.stack) == 0 || d.stack[len(d.stack)-1].Name != strings.ToUpper(p.Value) {
+			return fmt.Errorf("%w: line %d: unexpected END:%s", ErrInvalidCalendar, at, p.Value)
+		}
+		c := d.stack[len(d.stack)-1]
+		d.stack = d.stack[:len(d.stack)-1]
+		if len(d.stack) == 1 && d.stack[0] == d.root {
+			d.ready = c
+		}
+	default:
+		if len(d.stack) == 0 {
+			return fmt.Errorf("%w: line %d: property outside of a component", ErrInvalidCalendar, at)
+		}
+		c := d.stack[len(d.stack)-1]
+		c.Properties = append(c.Properties, p)
+	}
+	return nil
+}
+
+// The parseProperty() function splits a content line into its name,
+// parameters and value
+func parseProperty(content string) (Property, error) {
+	p := Property{Params: make(map[string]string)}
+	// The value starts at the first colon that is not in a quoted parameter
+	inQuotes, colon := false, -1
+	for i, c := range content {
+		if c == '"' {
+			inQuotes = !inQuotes
+		}
+		if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, errors.New("missing colon")
+	}
+	p.Value = content[colon+1:]
+	parts := strings.Split(content[:colon], ";")
+	p.Name = strings.ToUpper(parts[0])
+	if p.Name == "" {
+		return p, errors.New("missing property name")
+	}
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		p.Params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return p, nil
+}
+
+// Time() reads a DATE or DATE-TIME value. Times without a zone are read in
+// the TZID zone if there is one and in UTC otherwise
+func (p Property) Time() (time.Time, error) {
+	if p.Params["VALUE"] == "DATE" || len(p.Value) == len(DateLayout) {
+		return time.Parse(DateLayout, p.Value)
+	}
+	if strings.HasSuffix(p.Value, "Z") {
+		return time.Parse(DateTimeLayout, p.Value)
+	}
+	loc := time.UTC
+	if tzid := p.Params["TZID"]; tzid != "" {
+		var err error
+		loc, err = time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.ParseInLocation("20060102T150405", p.Value, loc)
+}
+
+// Text() returns a TEXT value without its escapes
+func (p Property) Text() string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n")
+	return r.Replace(p.Value)
+}
+
+// EscapeText() escapes a string for use as a TEXT value
+func EscapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// A Writer writes content lines, folding them at 75 octets and ending them
+// with CRLF as RFC 5545 asks
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteLine() writes a content line. The value must already be escaped
+func (w *Writer) WriteLine(name string, value string) {
+	if w.err != nil {
+		return
+	}
+	line := name + ":" + value
+	var b strings.Builder
+	// Continuation lines lose an octet to the leading space
+	for limit := 75; len(line) > limit; limit = 74 {
+		// Do not cut a UTF-8 character in half
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	_, w.err = io.WriteString(w.w, b.String())
+}
+
+// Err() returns the first error that happened while writing
+func (w *Writer) Err() error {
+	return w.err
+}
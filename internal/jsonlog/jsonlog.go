@@ -0,0 +1,123 @@
+// Filename: internal/jsonlog/jsonlog.go
+
+// Package jsonlog writes leveled log entries as one JSON object per line
+package jsonlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+	LevelFatal
+	// Nothing is logged at LevelOff
+	LevelOff
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelError:
+		return "ERROR"
+	case LevelFatal:
+		return "FATAL"
+	default:
+		return ""
+	}
+}
+
+// ParseLevel() returns the level with a name, e.g. for a command line flag
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("jsonlog: unknown level %q", s)
+	}
+}
+
+// A Logger writes the entries at or above its minimum level. It is safe for
+// concurrent use
+type Logger struct {
+	out      io.Writer
+	minLevel Level
+	mu       sync.Mutex
+}
+
+// New() creates a Logger that writes to out
+func New(out io.Writer, minLevel Level) *Logger {
+	return &Logger{out: out, minLevel: minLevel}
+}
+
+// PrintDebug() logs a message that is only useful while debugging
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
+}
+
+// PrintInfo() logs a message about normal operation
+func (l *Logger) PrintInfo(message string, properties map[string]string) {
+	l.print(LevelInfo, message, properties)
+}
+
+// PrintError() logs an error
+func (l *Logger) PrintError(err error, properties map[string]string) {
+	l.print(LevelError, err.Error(), properties)
+}
+
+// PrintFatal() logs an error and exits the application
+func (l *Logger) PrintFatal(err error, properties map[string]string) {
+	l.print(LevelFatal, err.Error(), properties)
+	os.Exit(1)
+}
+
+func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+	if level < l.minLevel {
+		return 0, nil
+	}
+	entry := struct {
+		Level      string            `json:"level"`
+		Time       string            `json:"time"`
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties,omitempty"`
+	}{
+		Level:      level.String(),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Message:    message,
+		Properties: properties,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Write(append(line, '\n'))
+}
+
+// Write() logs p as an error. It lets the Logger be the ErrorLog of an
+// http.Server
+func (l *Logger) Write(p []byte) (n int, err error) {
+	return l.print(LevelError, strings.TrimSpace(string(p)), nil)
+}
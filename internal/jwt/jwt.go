@@ -0,0 +1,183 @@
+// Filename: internal/jwt/jwt.go
+
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The supported signing algorithms
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims holds the registered claims we put into our access tokens
+type Claims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Expiry    int64  `json:"exp"`
+	// Session links the access token to the refresh token family it was
+	// issued from
+	Session string `json:"sid,omitempty"`
+}
+
+// The header of every token we sign
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Keys holds the key material used to sign and verify tokens
+type Keys struct {
+	alg     string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// LoadKeys() reads the key for the given algorithm from a file. For HS256 the
+// file holds the raw shared secret, for EdDSA a PEM encoded PKCS #8 private key
+func LoadKeys(alg string, path string) (*Keys, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case HS256:
+		secret := []byte(strings.TrimSpace(string(contents)))
+		// Anything shorter than the hash output weakens the signature
+		if len(secret) < sha256.Size {
+			return nil, fmt.Errorf("jwt: HS256 secret must be at least %d bytes", sha256.Size)
+		}
+		return &Keys{alg: HS256, secret: secret}, nil
+	case EdDSA:
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, errors.New("jwt: no PEM block found in key file")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt: key file does not hold an Ed25519 private key")
+		}
+		return &Keys{alg: EdDSA, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+}
+
+// Sign() encodes and signs the claims as a compact JWT
+func (k *Keys) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: k.alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encode(h) + "." + encode(c)
+	return signingInput + "." + encode(k.signature([]byte(signingInput))), nil
+}
+
+// Verify() checks the signature, the issuer and audience, and the time based
+// claims of a token and returns its claims
+func (k *Keys) Verify(token string, now time.Time, issuer string, audience string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	// Check the header before anything else so a token cannot pick its
+	// own algorithm
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil || h.Alg != k.alg {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch k.alg {
+	case HS256:
+		if !hmac.Equal(signature, k.signature(signingInput)) {
+			return nil, ErrInvalidToken
+		}
+	case EdDSA:
+		if !ed25519.Verify(k.public, signingInput, signature) {
+			return nil, ErrInvalidToken
+		}
+	}
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	// Only accept tokens that were issued by us for us
+	if claims.Issuer != issuer || claims.Audience != audience {
+		return nil, ErrInvalidToken
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// The signature() method computes the raw signature over the signing input
+func (k *Keys) signature(signingInput []byte) []byte {
+	if k.alg == EdDSA {
+		return ed25519.Sign(k.private, signingInput)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(segment string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// RandomKeys() returns a HS256 key with a random secret. Tokens signed with it
+// do not survive a restart, so it is only meant for development
+func RandomKeys() (*Keys, error) {
+	secret := make([]byte, sha256.Size)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Keys{alg: HS256, secret: secret}, nil
+}
@@ -0,0 +1,141 @@
+// Filename: internal/jwt/jwt_test.go
+
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "todo.jamesfaber.net"
+	testAudience = "todo.jamesfaber.net/api"
+)
+
+var testNow = time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+// The validClaims() function returns the claims of a token that is good at
+// testNow
+func validClaims() Claims {
+	return Claims{
+		Subject:   "42",
+		Issuer:    testIssuer,
+		Audience:  testAudience,
+		IssuedAt:  testNow.Add(-time.Minute).Unix(),
+		NotBefore: testNow.Add(-time.Minute).Unix(),
+		Expiry:    testNow.Add(15 * time.Minute).Unix(),
+		Session:   "family",
+	}
+}
+
+// The rawToken() function builds a token with any header, so tokens we never
+// sign can be made. sign gets the signing input and returns the signature
+func rawToken(t *testing.T, h header, claims Claims, sign func([]byte) []byte) string {
+	t.Helper()
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := encode(hb) + "." + encode(cb)
+	return signingInput + "." + encode(sign([]byte(signingInput)))
+}
+
+func testKeys(t *testing.T) (hs *Keys, ed *Keys) {
+	t.Helper()
+	hs, err := RandomKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hs, &Keys{alg: EdDSA, private: private, public: public}
+}
+
+func TestVerify(t *testing.T) {
+	hs, ed := testKeys(t)
+	otherHS, otherED := testKeys(t)
+	sign := func(k *Keys, change func(*Claims)) string {
+		claims := validClaims()
+		if change != nil {
+			change(&claims)
+		}
+		token, err := k.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name  string
+		keys  *Keys
+		token string
+		want  error
+	}{
+		{"valid HS256", hs, sign(hs, nil), nil},
+		{"valid EdDSA", ed, sign(ed, nil), nil},
+		{"HS256 signed with another secret", hs, sign(otherHS, nil), ErrInvalidToken},
+		{"EdDSA signed with another key", ed, sign(otherED, nil), ErrInvalidToken},
+		{"claims changed after signing", hs, func() string {
+			parts := strings.Split(sign(hs, nil), ".")
+			claims := validClaims()
+			claims.Subject = "1"
+			cb, _ := json.Marshal(claims)
+			return parts[0] + "." + encode(cb) + "." + parts[2]
+		}(), ErrInvalidToken},
+		{"alg none", hs, rawToken(t, header{Alg: "none", Typ: "JWT"}, validClaims(), func([]byte) []byte { return nil }), ErrInvalidToken},
+		{"alg none without a signature", ed, strings.TrimSuffix(rawToken(t, header{Alg: "none", Typ: "JWT"}, validClaims(), func([]byte) []byte { return nil }), "."), ErrInvalidToken},
+		// The public key is no secret, so an HMAC made with it must not pass
+		// for a token of the Ed25519 key
+		{"HS256 with the EdDSA public key as secret", ed, rawToken(t, header{Alg: HS256, Typ: "JWT"}, validClaims(), func(input []byte) []byte {
+			mac := hmac.New(sha256.New, ed.public)
+			mac.Write(input)
+			return mac.Sum(nil)
+		}), ErrInvalidToken},
+		{"EdDSA token for an HS256 key", hs, sign(ed, nil), ErrInvalidToken},
+		{"expired", hs, sign(hs, func(c *Claims) { c.Expiry = testNow.Unix() }), ErrExpiredToken},
+		{"no expiry", hs, sign(hs, func(c *Claims) { c.Expiry = 0 }), ErrExpiredToken},
+		{"not valid yet", hs, sign(hs, func(c *Claims) { c.NotBefore = testNow.Add(time.Minute).Unix() }), ErrInvalidToken},
+		{"wrong issuer", hs, sign(hs, func(c *Claims) { c.Issuer = "someone.else" }), ErrInvalidToken},
+		{"no issuer", hs, sign(hs, func(c *Claims) { c.Issuer = "" }), ErrInvalidToken},
+		{"wrong audience", hs, sign(hs, func(c *Claims) { c.Audience = "todo.jamesfaber.net/admin" }), ErrInvalidToken},
+		{"no audience", ed, sign(ed, func(c *Claims) { c.Audience = "" }), ErrInvalidToken},
+		{"empty", hs, "", ErrInvalidToken},
+		{"two segments", hs, strings.Join(strings.Split(sign(hs, nil), ".")[:2], "."), ErrInvalidToken},
+		{"four segments", hs, sign(hs, nil) + ".x", ErrInvalidToken},
+		{"header not base64", hs, "!!!." + strings.SplitN(sign(hs, nil), ".", 2)[1], ErrInvalidToken},
+		{"header not JSON", hs, rawSegments(hs, encode([]byte("alg")), encode([]byte(`{"sub":"42"}`))), ErrInvalidToken},
+		{"claims not JSON", hs, rawSegments(hs, encode([]byte(`{"alg":"HS256","typ":"JWT"}`)), encode([]byte("sub"))), ErrInvalidToken},
+		{"signature not base64", hs, strings.Join(strings.Split(sign(hs, nil), ".")[:2], ".") + ".***", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.keys.Verify(tt.token, testNow, testIssuer, testAudience)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (claims.Subject != "42" || claims.Session != "family") {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+// The rawSegments() function signs a header and claims segment as they are,
+// so segments that are not valid JSON still carry a good signature
+func rawSegments(k *Keys, h string, c string) string {
+	signingInput := h + "." + c
+	return signingInput + "." + encode(k.signature([]byte(signingInput)))
+}
@@ -0,0 +1,133 @@
+// Filename: internal/mailer/mailer.go
+
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// The email templates are compiled into the binary
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends emails through an SMTP server
+type Mailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// New() creates a Mailer for the given SMTP server
+func New(host string, port int, username, password, sender string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return Mailer{
+		addr:   fmt.Sprintf("%s:%d", host, port),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+// Send() renders the "subject", "plainBody" and "htmlBody" templates of the
+// template file and sends the result to the recipient
+func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	// The subject and the plain text body are not HTML, so they must not be
+	// escaped as such
+	textTmpl, err := texttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+	subject := new(bytes.Buffer)
+	if err = textTmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return err
+	}
+	plainBody := new(bytes.Buffer)
+	if err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return err
+	}
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+	htmlBody := new(bytes.Buffer)
+	if err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return err
+	}
+
+	// Build a multipart/alternative message holding both bodies
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", plainBody.Bytes()},
+		{"text/html; charset=UTF-8", htmlBody.Bytes()},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(part.content); err != nil {
+			return err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	// The subject can hold names that users picked, so it is Q-encoded. A
+	// line break in it cannot start a header of its own then
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", encodeAddress(m.sender))
+	fmt.Fprintf(msg, "To: %s\r\n", encodeAddress(recipient))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+
+	// Try sending the email up to three times before giving up
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, senderAddress(m.sender), []string{recipient}, msg.Bytes())
+		if err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+// The senderAddress() function strips the display name from a sender such as
+// "Todo <no-reply@todo.jamesfaber.net>"
+func senderAddress(sender string) string {
+	if start := strings.LastIndex(sender, "<"); start >= 0 {
+		return strings.TrimSuffix(sender[start+1:], ">")
+	}
+	return sender
+}
+
+// The encodeAddress() function formats an address such as
+// "Todo <no-reply@todo.jamesfaber.net>" for a header, Q-encoding the display
+// name when it needs it. Anything that does not parse is Q-encoded as a whole
+func encodeAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return mime.QEncoding.Encode("UTF-8", address)
+	}
+	return parsed.String()
+}
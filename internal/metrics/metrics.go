@@ -0,0 +1,286 @@
+// Filename: internal/metrics/metrics.go
+
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit latencies in seconds of a network service
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A metric writes its samples after the HELP and TYPE lines
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+type family struct {
+	name, help, kind string
+	metric           metric
+}
+
+// A Registry holds the metrics that are written out together
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metrics: " + name + " is registered twice")
+		}
+	}
+	r.families = append(r.families, family{name: name, help: help, kind: kind, metric: m})
+}
+
+// Write() writes every metric in the text exposition format, sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family{}, r.families...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		f.metric.write(bw)
+	}
+	return bw.Flush()
+}
+
+// The labels type turns label values into the {name="value"} part of a
+// sample line
+type labels []string
+
+func (l labels) format(values []string, extra ...string) string {
+	if len(l) == 0 && len(extra) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(l)+1)
+	for i, name := range l {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escape.Replace(values[i])))
+	}
+	// Extra labels come as name, value pairs
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escape.Replace(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (l labels) check(values []string) {
+	if len(values) != len(l) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(l)))
+	}
+}
+
+// The key() function joins label values so they can index a map. The
+// separator cannot appear in valid UTF-8
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// A CounterVec is a set of counters told apart by their label values
+type CounterVec struct {
+	name   string
+	labels labels
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+// NewCounterVec() registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{name: name, labels: labelNames, values: make(map[string]float64), keys: make(map[string][]string)}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Inc() adds one to the counter with the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add() adds v, which must not be negative, to the counter with the label
+// values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.labels.check(labelValues)
+	k := key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[k]; !ok {
+		c.keys[k] = append([]string{}, labelValues...)
+	}
+	c.values[k] += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labels.format(c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+// A Gauge is a value that can go up and down
+type Gauge struct {
+	name  string
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge() registers a gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+}
+
+// A funcMetric reads its value when the metrics are written, for values
+// that are kept elsewhere such as the stats of a connection pool
+type funcMetric struct {
+	name string
+	fn   func() float64
+}
+
+// NewGaugeFunc() registers a gauge whose value is read from fn
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", &funcMetric{name: name, fn: fn})
+}
+
+// NewCounterFunc() registers a counter whose value is read from fn
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", &funcMetric{name: name, fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+// A HistogramVec is a set of histograms told apart by their label values
+type HistogramVec struct {
+	name    string
+	labels  labels
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+	keys    map[string][]string
+}
+
+type histogram struct {
+	// counts[i] is the number of observations in bucket i alone; the last
+	// one is the +Inf bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec() registers a histogram with the given upper bounds, which
+// must be sorted, and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{name: name, labels: labelNames, buckets: buckets, series: make(map[string]*histogram), keys: make(map[string][]string)}
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// Observe() adds v to the histogram with the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.labels.check(labelValues)
+	k := key(labelValues)
+	// The first bucket whose upper bound is not below v
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[k] = s
+		h.keys[k] = append([]string{}, labelValues...)
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.keys) {
+		s, values := h.series[k], h.keys[k]
+		// Bucket counts are cumulative in the exposition format
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels.format(values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels.format(values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels.format(values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels.format(values), s.count)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
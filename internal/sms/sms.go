@@ -0,0 +1,36 @@
+// Filename: internal/sms/sms.go
+
+package sms
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SMSSender is implemented by anything that can deliver a text message to a
+// phone number
+type SMSSender interface {
+	Send(to string, message string) error
+}
+
+// LogSender writes every message to a log instead of sending it, so the API
+// runs without an SMS provider
+type LogSender struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewLogSender() creates a LogSender that writes to out
+func NewLogSender(out io.Writer) *LogSender {
+	return &LogSender{out: out}
+}
+
+// Send() writes the message with a timestamp and the recipient
+func (s *LogSender) Send(to string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.out, "%s SMS to=%q message=%q\n", time.Now().UTC().Format(time.RFC3339), to, message)
+	return err
+}
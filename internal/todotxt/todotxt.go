@@ -0,0 +1,146 @@
+// Filename: internal/todotxt/todotxt.go
+
+// Package todotxt reads and writes tasks in the todo.txt format
+// (https://github.com/todotxt/todo.txt)
+package todotxt
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The layout of the dates in a todo.txt line
+const DateLayout = "2006-01-02"
+
+// ErrEmptyTask is returned for a line without a description
+var ErrEmptyTask = errors.New("the task has no description")
+
+var (
+	priorityRX = regexp.MustCompile(`^\([A-Z]\)$`)
+	dateRX     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// An Extension is a key:value pair in the description
+type Extension struct {
+	Key   string
+	Value string
+}
+
+// A Task is one line of a todo.txt file
+type Task struct {
+	Completed      bool
+	Priority       string
+	CompletionDate *time.Time
+	CreationDate   *time.Time
+	Description    string
+	Projects       []string
+	Contexts       []string
+	// Extensions keep the order they were written in
+	Extensions []Extension
+}
+
+// Parse() reads a task from a todo.txt line. The +projects, @contexts and
+// key:value extensions are taken out of the description
+func Parse(line string) (Task, error) {
+	var task Task
+	fields := strings.Fields(line)
+
+	if len(fields) > 0 && fields[0] == "x" {
+		task.Completed = true
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && priorityRX.MatchString(fields[0]) {
+		task.Priority = fields[0][1:2]
+		fields = fields[1:]
+	}
+	// A completed task can have a completion date followed by a creation date
+	if date, ok := parseDate(fields); ok {
+		fields = fields[1:]
+		if next, ok := parseDate(fields); ok && task.Completed {
+			task.CompletionDate, task.CreationDate = &date, &next
+			fields = fields[1:]
+		} else if task.Completed {
+			task.CompletionDate = &date
+		} else {
+			task.CreationDate = &date
+		}
+	}
+
+	var words []string
+	for _, field := range fields {
+		switch {
+		case len(field) > 1 && field[0] == '+':
+			task.Projects = append(task.Projects, field[1:])
+		case len(field) > 1 && field[0] == '@':
+			task.Contexts = append(task.Contexts, field[1:])
+		case isExtension(field):
+			key, value, _ := strings.Cut(field, ":")
+			task.Extensions = append(task.Extensions, Extension{Key: key, Value: value})
+		default:
+			words = append(words, field)
+		}
+	}
+	task.Description = strings.Join(words, " ")
+	if task.Description == "" {
+		return task, ErrEmptyTask
+	}
+	return task, nil
+}
+
+// The parseDate() function reads a date from the first field
+func parseDate(fields []string) (time.Time, bool) {
+	if len(fields) == 0 || !dateRX.MatchString(fields[0]) {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(DateLayout, fields[0])
+	return date, err == nil
+}
+
+// The isExtension() function reports whether a field is a key:value pair.
+// Links such as https://example.com are left in the description
+func isExtension(field string) bool {
+	key, value, ok := strings.Cut(field, ":")
+	return ok && key != "" && value != "" && !strings.ContainsRune(value, ':') && !strings.HasPrefix(value, "//")
+}
+
+// Extension() returns the value of the first extension with the key
+func (t Task) Extension(key string) (string, bool) {
+	for _, ext := range t.Extensions {
+		if ext.Key == key {
+			return ext.Value, true
+		}
+	}
+	return "", false
+}
+
+// String() writes the task as a todo.txt line. Projects, contexts and
+// extensions come after the description
+func (t Task) String() string {
+	var fields []string
+	if t.Completed {
+		fields = append(fields, "x")
+	}
+	if t.Priority != "" {
+		fields = append(fields, "("+t.Priority+")")
+	}
+	// A creation date on a completed task needs a completion date before it
+	if t.Completed && t.CompletionDate != nil {
+		fields = append(fields, t.CompletionDate.Format(DateLayout))
+	}
+	if t.CreationDate != nil && (!t.Completed || t.CompletionDate != nil) {
+		fields = append(fields, t.CreationDate.Format(DateLayout))
+	}
+	fields = append(fields, t.Description)
+	for _, project := range t.Projects {
+		fields = append(fields, "+"+project)
+	}
+	for _, context := range t.Contexts {
+		fields = append(fields, "@"+context)
+	}
+	for _, ext := range t.Extensions {
+		fields = append(fields, ext.Key+":"+ext.Value)
+	}
+	return strings.Join(fields, " ")
+}
@@ -5,6 +5,7 @@ package validator
 import (
 	"net/url"
 	"regexp"
+	"unicode"
 )
 
 var (
@@ -45,6 +46,17 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// NoControl() checks that a string has no control characters, such as line
+// breaks, which could end a header or a log line early
+func NoControl(value string) bool {
+	for _, c := range value {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return true
+}
+
 // ValidWebsite() checks if a string value is a valid web URL
 func ValidWebsite(website string) bool {
 	_, err := url.ParseRequestURI(website)
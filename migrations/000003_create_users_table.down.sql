@@ -0,0 +1,3 @@
+--Filename: migrations/000003_create_users_table.down.sql
+
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,3 @@
+--Filename: migrations/000004_create_refresh_tokens_table.down.sql
+
+DROP TABLE IF EXISTS refresh_tokens;
@@ -0,0 +1,14 @@
+--Filename: migrations/000004_create_refresh_tokens_table.up.sql
+
+-- Every refresh token belongs to a family. A family starts when the user
+-- logs in and each rotation adds a new member to it
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    family text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
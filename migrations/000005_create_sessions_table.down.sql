@@ -0,0 +1,4 @@
+--Filename: migrations/000005_create_sessions_table.down.sql
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_family_fkey;
+DROP TABLE IF EXISTS sessions;
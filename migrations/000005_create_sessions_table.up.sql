@@ -0,0 +1,23 @@
+--Filename: migrations/000005_create_sessions_table.up.sql
+
+-- A session is a refresh token family. Revoking it removes its tokens
+CREATE TABLE IF NOT EXISTS sessions (
+    id text PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_agent text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Families issued before sessions existed become sessions of their own
+INSERT INTO sessions (id, user_id, created_at, last_used_at)
+SELECT family, user_id, MIN(created_at), MAX(created_at)
+FROM refresh_tokens
+GROUP BY family, user_id
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_family_fkey
+    FOREIGN KEY (family) REFERENCES sessions (id) ON DELETE CASCADE;
@@ -0,0 +1,10 @@
+--Filename: migrations/000006_create_organizations_tables.down.sql
+
+DROP INDEX IF EXISTS todo_org_id_idx;
+ALTER TABLE todo DROP CONSTRAINT IF EXISTS todo_list_fkey;
+ALTER TABLE todo DROP COLUMN IF EXISTS list_id;
+ALTER TABLE todo DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS lists;
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,56 @@
+--Filename: migrations/000006_create_organizations_tables.up.sql
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version int NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS org_members_user_id_idx ON org_members (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    hash bytea PRIMARY KEY,
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    email citext NOT NULL,
+    role text NOT NULL CHECK (role IN ('admin', 'member')),
+    invited_by bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version int NOT NULL DEFAULT 1,
+    -- Lets todo reference a list and its organization together
+    UNIQUE (id, org_id)
+);
+
+CREATE INDEX IF NOT EXISTS lists_org_id_idx ON lists (org_id);
+
+-- Everything created before organizations existed was shared by everyone,
+-- so it moves into one organization that every existing user joins
+INSERT INTO organizations (name) SELECT 'Default' WHERE EXISTS (SELECT 1 FROM todo) OR EXISTS (SELECT 1 FROM users);
+INSERT INTO org_members (org_id, user_id, role)
+SELECT (SELECT MIN(id) FROM organizations), id, 'admin' FROM users;
+
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS org_id bigint REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS list_id bigint;
+UPDATE todo SET org_id = (SELECT MIN(id) FROM organizations);
+ALTER TABLE todo ALTER COLUMN org_id SET NOT NULL;
+-- A todo can only be filed under a list of its own organization
+ALTER TABLE todo ADD CONSTRAINT todo_list_fkey
+    FOREIGN KEY (list_id, org_id) REFERENCES lists (id, org_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS todo_org_id_idx ON todo (org_id);
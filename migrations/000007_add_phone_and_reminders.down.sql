@@ -0,0 +1,10 @@
+--Filename: migrations/000007_add_phone_and_reminders.down.sql
+
+DROP INDEX IF EXISTS todo_due_at_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS reminded_at;
+ALTER TABLE todo DROP COLUMN IF EXISTS due_at;
+ALTER TABLE todo DROP COLUMN IF EXISTS created_by;
+DROP TABLE IF EXISTS phone_verifications;
+ALTER TABLE users DROP COLUMN IF EXISTS sms_reminders;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
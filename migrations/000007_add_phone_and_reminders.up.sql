@@ -0,0 +1,21 @@
+--Filename: migrations/000007_add_phone_and_reminders.up.sql
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified bool NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sms_reminders bool NOT NULL DEFAULT false;
+
+-- One pending verification code per user
+CREATE TABLE IF NOT EXISTS phone_verifications (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    phone text NOT NULL,
+    hash bytea NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    attempts int NOT NULL DEFAULT 0
+);
+
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS due_at timestamp(0) with time zone;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS reminded_at timestamp(0) with time zone;
+
+-- The reminder worker only looks at todos that still need a reminder
+CREATE INDEX IF NOT EXISTS todo_due_at_idx ON todo (due_at) WHERE reminded_at IS NULL;
@@ -0,0 +1,5 @@
+--Filename: migrations/000008_add_todo_keyset_indexes.down.sql
+
+DROP INDEX IF EXISTS todo_org_task_id_idx;
+DROP INDEX IF EXISTS todo_org_name_id_idx;
+DROP INDEX IF EXISTS todo_org_id_id_idx;
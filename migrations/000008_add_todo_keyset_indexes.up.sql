@@ -0,0 +1,6 @@
+--Filename: migrations/000008_add_todo_keyset_indexes.up.sql
+
+-- Cursor pages seek to (sort column, id) within an organization
+CREATE INDEX IF NOT EXISTS todo_org_id_id_idx ON todo (org_id, id);
+CREATE INDEX IF NOT EXISTS todo_org_name_id_idx ON todo (org_id, name, id);
+CREATE INDEX IF NOT EXISTS todo_org_task_id_idx ON todo (org_id, task, id);
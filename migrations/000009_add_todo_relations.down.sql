@@ -0,0 +1,6 @@
+--Filename: migrations/000009_add_todo_relations.down.sql
+
+DROP TABLE IF EXISTS todo_comments;
+DROP TABLE IF EXISTS todo_tags;
+DROP INDEX IF EXISTS todo_parent_id_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS parent_id;
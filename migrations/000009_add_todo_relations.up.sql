@@ -0,0 +1,20 @@
+--Filename: migrations/000009_add_todo_relations.up.sql
+
+-- A subtask is a todo with a parent todo
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES todo ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS todo_parent_id_idx ON todo (parent_id);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+    todo_id bigint NOT NULL REFERENCES todo ON DELETE CASCADE,
+    tag text NOT NULL,
+    PRIMARY KEY (todo_id, tag)
+);
+
+CREATE TABLE IF NOT EXISTS todo_comments (
+    id bigserial PRIMARY KEY,
+    todo_id bigint NOT NULL REFERENCES todo ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS todo_comments_todo_id_idx ON todo_comments (todo_id);
@@ -0,0 +1,5 @@
+--Filename: migrations/000010_add_todo_txt_fields.down.sql
+
+ALTER TABLE todo DROP COLUMN IF EXISTS extensions;
+ALTER TABLE todo DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE todo DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,7 @@
+--Filename: migrations/000010_add_todo_txt_fields.up.sql
+
+-- Fields for todos kept in the todo.txt format
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS priority text CHECK (priority ~ '^[A-Z]$');
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS completed_at timestamp(0) with time zone;
+-- key:value pairs we do not know, kept in order so they can be written back
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS extensions text[] NOT NULL DEFAULT '{}';
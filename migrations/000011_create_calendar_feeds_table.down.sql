@@ -0,0 +1,3 @@
+--Filename: migrations/000011_create_calendar_feeds_table.down.sql
+
+DROP TABLE IF EXISTS calendar_feeds;
@@ -0,0 +1,10 @@
+--Filename: migrations/000011_create_calendar_feeds_table.up.sql
+
+-- The secret token in a calendar feed URL. One feed per user and organization
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, org_id)
+);
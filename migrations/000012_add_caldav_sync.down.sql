@@ -0,0 +1,9 @@
+--Filename: migrations/000012_add_caldav_sync.down.sql
+
+DROP TRIGGER IF EXISTS todo_sync ON todo;
+DROP FUNCTION IF EXISTS todo_sync();
+DROP TABLE IF EXISTS todo_tombstones;
+ALTER TABLE todo DROP COLUMN IF EXISTS sync_seq;
+DROP SEQUENCE IF EXISTS todo_sync_seq;
+ALTER TABLE todo DROP COLUMN IF EXISTS ical_uid;
+ALTER TABLE todo DROP COLUMN IF EXISTS dav_name;
@@ -0,0 +1,39 @@
+--Filename: migrations/000012_add_caldav_sync.up.sql
+
+-- CalDAV clients pick the name and UID of the todos they create
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS dav_name text;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS ical_uid text;
+CREATE UNIQUE INDEX IF NOT EXISTS todo_dav_name_idx ON todo (org_id, dav_name) WHERE dav_name IS NOT NULL;
+
+-- Every change to a todo takes the next number, and the highest number in a
+-- list is its sync token
+CREATE SEQUENCE IF NOT EXISTS todo_sync_seq;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS sync_seq bigint NOT NULL DEFAULT nextval('todo_sync_seq');
+CREATE INDEX IF NOT EXISTS todo_sync_seq_idx ON todo (org_id, list_id, sync_seq);
+
+-- Todos that left a list, so clients can be told to remove them
+CREATE TABLE IF NOT EXISTS todo_tombstones (
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    list_id bigint,
+    name text NOT NULL,
+    sync_seq bigint NOT NULL DEFAULT nextval('todo_sync_seq')
+);
+CREATE INDEX IF NOT EXISTS todo_tombstones_sync_seq_idx ON todo_tombstones (org_id, list_id, sync_seq);
+
+CREATE OR REPLACE FUNCTION todo_sync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.list_id IS DISTINCT FROM NEW.list_id) THEN
+        INSERT INTO todo_tombstones (org_id, list_id, name)
+        VALUES (OLD.org_id, OLD.list_id, COALESCE(OLD.dav_name, 'todo-' || OLD.id || '.ics'));
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    NEW.sync_seq := nextval('todo_sync_seq');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS todo_sync ON todo;
+CREATE TRIGGER todo_sync BEFORE INSERT OR UPDATE OR DELETE ON todo
+    FOR EACH ROW EXECUTE FUNCTION todo_sync();
@@ -0,0 +1,3 @@
+--Filename: migrations/000013_add_todo_updated_at.down.sql
+
+ALTER TABLE todo DROP COLUMN IF EXISTS updated_at;
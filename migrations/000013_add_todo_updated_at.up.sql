@@ -0,0 +1,10 @@
+--Filename: migrations/000013_add_todo_updated_at.up.sql
+
+-- Existing todos were last changed no later than they were created as far as
+-- we know. The sync trigger is off so the backfill is not a change for CalDAV
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone;
+ALTER TABLE todo DISABLE TRIGGER todo_sync;
+UPDATE todo SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE todo ENABLE TRIGGER todo_sync;
+ALTER TABLE todo ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE todo ALTER COLUMN updated_at SET NOT NULL;
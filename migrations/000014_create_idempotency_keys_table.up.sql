@@ -0,0 +1,15 @@
+--Filename: migrations/000014_create_idempotency_keys_table.up.sql
+
+-- The response sent for an Idempotency-Key, so a retry gets it again instead
+-- of repeating the request. A key is only stored once its request finished
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    key text NOT NULL,
+    fingerprint bytea NOT NULL,
+    status integer,
+    headers jsonb,
+    body bytea,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
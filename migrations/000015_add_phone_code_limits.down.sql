@@ -0,0 +1,4 @@
+--Filename: migrations/000015_add_phone_code_limits.down.sql
+
+ALTER TABLE phone_verifications DROP COLUMN IF EXISTS window_start;
+ALTER TABLE phone_verifications DROP COLUMN IF EXISTS codes_sent;
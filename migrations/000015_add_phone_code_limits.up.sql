@@ -0,0 +1,6 @@
+--Filename: migrations/000015_add_phone_code_limits.up.sql
+
+-- How many codes were sent to the user since window_start, so that asking for
+-- new codes cannot be used to get more attempts at guessing one
+ALTER TABLE phone_verifications ADD COLUMN IF NOT EXISTS codes_sent int NOT NULL DEFAULT 1;
+ALTER TABLE phone_verifications ADD COLUMN IF NOT EXISTS window_start timestamp(0) with time zone NOT NULL DEFAULT NOW();
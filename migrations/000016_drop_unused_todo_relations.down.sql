@@ -0,0 +1,13 @@
+--Filename: migrations/000016_drop_unused_todo_relations.down.sql
+
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES todo ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS todo_parent_id_idx ON todo (parent_id);
+
+CREATE TABLE IF NOT EXISTS todo_comments (
+    id bigserial PRIMARY KEY,
+    todo_id bigint NOT NULL REFERENCES todo ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS todo_comments_todo_id_idx ON todo_comments (todo_id);
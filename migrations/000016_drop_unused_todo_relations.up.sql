@@ -0,0 +1,7 @@
+--Filename: migrations/000016_drop_unused_todo_relations.up.sql
+
+-- Nothing ever wrote comments or subtasks, so they are dropped along with
+-- their ?include= options. Tags are kept, the todo.txt import writes them
+DROP TABLE IF EXISTS todo_comments;
+DROP INDEX IF EXISTS todo_parent_id_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,3 @@
+--Filename: migrations/000017_add_todo_ical_uid_index.down.sql
+
+DROP INDEX IF EXISTS todo_ical_uid_idx;
@@ -0,0 +1,4 @@
+--Filename: migrations/000017_add_todo_ical_uid_index.up.sql
+
+-- The calendar import matches VTODOs to todos by the UID they came with
+CREATE INDEX IF NOT EXISTS todo_ical_uid_idx ON todo (org_id, ical_uid) WHERE ical_uid IS NOT NULL;
@@ -0,0 +1,3 @@
+--Filename: migrations/000018_create_dav_passwords_table.down.sql
+
+DROP TABLE IF EXISTS dav_passwords;
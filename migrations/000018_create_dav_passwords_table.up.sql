@@ -0,0 +1,11 @@
+--Filename: migrations/000018_create_dav_passwords_table.up.sql
+
+-- The app password a CalDAV client logs in with. Unlike a calendar feed token
+-- it can change todos. One password per user and organization
+CREATE TABLE IF NOT EXISTS dav_passwords (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, org_id)
+);
@@ -0,0 +1,26 @@
+--Filename: migrations/000019_sync_todos_by_transaction.down.sql
+
+CREATE SEQUENCE IF NOT EXISTS todo_sync_seq;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS sync_seq bigint NOT NULL DEFAULT nextval('todo_sync_seq');
+ALTER TABLE todo_tombstones ADD COLUMN IF NOT EXISTS sync_seq bigint NOT NULL DEFAULT nextval('todo_sync_seq');
+CREATE INDEX IF NOT EXISTS todo_sync_seq_idx ON todo (org_id, list_id, sync_seq);
+CREATE INDEX IF NOT EXISTS todo_tombstones_sync_seq_idx ON todo_tombstones (org_id, list_id, sync_seq);
+
+CREATE OR REPLACE FUNCTION todo_sync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.list_id IS DISTINCT FROM NEW.list_id) THEN
+        INSERT INTO todo_tombstones (org_id, list_id, name)
+        VALUES (OLD.org_id, OLD.list_id, COALESCE(OLD.dav_name, 'todo-' || OLD.id || '.ics'));
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    NEW.sync_seq := nextval('todo_sync_seq');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS todo_sync_xid_idx;
+DROP INDEX IF EXISTS todo_tombstones_sync_xid_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS sync_xid;
+ALTER TABLE todo_tombstones DROP COLUMN IF EXISTS sync_xid;
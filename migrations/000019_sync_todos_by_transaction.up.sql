@@ -0,0 +1,31 @@
+--Filename: migrations/000019_sync_todos_by_transaction.up.sql
+
+-- Sync numbers came from a sequence when a change was made but were only seen
+-- once it committed, so a slow transaction could commit below a sync token
+-- that was already handed out, and clients never got its change. Changes now
+-- keep the id of the transaction that made them instead, and a sync token
+-- never goes past a transaction that is still running
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS sync_xid bigint NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+ALTER TABLE todo_tombstones ADD COLUMN IF NOT EXISTS sync_xid bigint NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+CREATE INDEX IF NOT EXISTS todo_sync_xid_idx ON todo (org_id, list_id, sync_xid);
+CREATE INDEX IF NOT EXISTS todo_tombstones_sync_xid_idx ON todo_tombstones (org_id, list_id, sync_xid);
+
+CREATE OR REPLACE FUNCTION todo_sync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.list_id IS DISTINCT FROM NEW.list_id) THEN
+        INSERT INTO todo_tombstones (org_id, list_id, name)
+        VALUES (OLD.org_id, OLD.list_id, COALESCE(OLD.dav_name, 'todo-' || OLD.id || '.ics'));
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    NEW.sync_xid := pg_current_xact_id()::text::bigint;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS todo_sync_seq_idx;
+DROP INDEX IF EXISTS todo_tombstones_sync_seq_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS sync_seq;
+ALTER TABLE todo_tombstones DROP COLUMN IF EXISTS sync_seq;
+DROP SEQUENCE IF EXISTS todo_sync_seq;
@@ -0,0 +1,6 @@
+--Filename: migrations/000020_add_subtasks_and_comments.down.sql
+
+DROP TABLE IF EXISTS todo_comments;
+DROP INDEX IF EXISTS todo_parent_id_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS parent_id;
+ALTER TABLE todo DROP CONSTRAINT IF EXISTS todo_id_org_id_key;
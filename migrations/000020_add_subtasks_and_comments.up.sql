@@ -0,0 +1,24 @@
+--Filename: migrations/000020_add_subtasks_and_comments.up.sql
+
+-- Subtasks and comments come back now that the API writes them. Both point
+-- at a todo of their own organization, like todo_list_fkey does for lists
+ALTER TABLE todo ADD CONSTRAINT todo_id_org_id_key UNIQUE (id, org_id);
+
+-- A subtask is a todo with a parent todo. It goes when its parent does
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS parent_id bigint;
+ALTER TABLE todo ADD CONSTRAINT todo_parent_fkey
+    FOREIGN KEY (parent_id, org_id) REFERENCES todo (id, org_id) ON DELETE CASCADE;
+ALTER TABLE todo ADD CONSTRAINT todo_parent_check CHECK (parent_id <> id);
+CREATE INDEX IF NOT EXISTS todo_parent_id_idx ON todo (parent_id);
+
+CREATE TABLE IF NOT EXISTS todo_comments (
+    id bigserial PRIMARY KEY,
+    todo_id bigint NOT NULL,
+    org_id bigint NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT todo_comments_todo_fkey
+        FOREIGN KEY (todo_id, org_id) REFERENCES todo (id, org_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS todo_comments_todo_id_idx ON todo_comments (todo_id, id);
@@ -0,0 +1,5 @@
+--Filename: migrations/000021_add_tombstone_retention.down.sql
+
+DROP TABLE IF EXISTS todo_tombstone_horizons;
+DROP INDEX IF EXISTS todo_tombstones_created_at_idx;
+ALTER TABLE todo_tombstones DROP COLUMN IF EXISTS created_at;
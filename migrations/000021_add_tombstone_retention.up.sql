@@ -0,0 +1,12 @@
+--Filename: migrations/000021_add_tombstone_retention.up.sql
+
+-- Tombstones are pruned once they are older than the retention. Clients with
+-- a sync token from before the newest pruned tombstone have to sync afresh,
+-- so the highest transaction id pruned in each organization is kept
+ALTER TABLE todo_tombstones ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS todo_tombstones_created_at_idx ON todo_tombstones (created_at);
+
+CREATE TABLE IF NOT EXISTS todo_tombstone_horizons (
+    org_id bigint PRIMARY KEY REFERENCES organizations ON DELETE CASCADE,
+    pruned_xid bigint NOT NULL
+);